@@ -0,0 +1,273 @@
+// Package client d-channel HTTP接口的Go客户端。
+//
+// 所有方法都通过 context 控制超时和取消；接口返回 fail 或 error 时，方法返回 *Error。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 返回消息的的类型，与 httpapi 相同
+const (
+	MSG_SUCCESS  = "success"
+	MSG_FAIL     = "fail"
+	MSG_UNKNOW   = "unknow"
+	MSG_ERROR    = "error"
+	MSG_CONFLICT = "conflict"
+)
+
+// 数据库类型，与 database 相同
+const (
+	STORETYPE_KV      = "keyvalue"
+	STORETYPE_DOCS    = "docstore"
+	STORETYPE_LOG     = "eventlog"
+	STORETYPE_COUNTER = "counter"
+	STORETYPE_FEED    = "feed"
+)
+
+// 选择实例的请求头，与 httpapi 相同
+const INSTANCE_HEADER = "X-DChannel-Instance"
+
+// Error 接口返回的 fail、error 或 conflict，Detail 为 data 中的说明
+type Error struct {
+	Message string
+	Detail  string
+}
+
+func (e *Error) Error() string {
+	return e.Message + ": " + e.Detail
+}
+
+// IsConflict 条件写入时数据已被其他entry修改
+func IsConflict(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Message == MSG_CONFLICT
+}
+
+// Client d-channel HTTP接口的客户端
+type Client struct {
+	BaseURL    string       //如 http://127.0.0.1:8000
+	Instance   string       //实例名称，为空时使用 default 实例
+	HTTPClient *http.Client //为nil时使用 http.DefaultClient
+}
+
+// New 创建客户端
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// WithInstance 返回使用名为name的实例的客户端，原客户端不变
+func (c *Client) WithInstance(name string) *Client {
+	n := *c
+	n.Instance = name
+	return &n
+}
+
+// 接口响应的数据结构
+type envelope struct {
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+	Next    string          `json:"next,omitempty"`
+}
+
+// 调用接口，把 data 解析到 out（为nil时忽略），返回分页的下一页游标
+func (c *Client) call(ctx context.Context, path string, in interface{}, out interface{}) (next string, err error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Instance != "" {
+		req.Header.Set(INSTANCE_HEADER, c.Instance)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: unexpected status %s", path, resp.Status)
+	}
+
+	env := &envelope{}
+	if err = json.NewDecoder(resp.Body).Decode(env); err != nil {
+		return "", fmt.Errorf("%s: decode response: %w", path, err)
+	}
+
+	if env.Message != MSG_SUCCESS {
+		return "", &Error{Message: env.Message, Detail: detail(env.Data)}
+	}
+
+	if out != nil && len(env.Data) > 0 {
+		if err = json.Unmarshal(env.Data, out); err != nil {
+			return "", fmt.Errorf("%s: decode data: %w", path, err)
+		}
+	}
+
+	return env.Next, nil
+}
+
+// 错误说明通常是字符串，其他类型原样返回json
+func detail(data json.RawMessage) string {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		return s
+	}
+	return string(data)
+}
+
+// BootOptions 启动实例的参数，见 httpapi 的 bootIn
+type BootOptions struct {
+	Passphrase string `json:"passphrase,omitempty"`
+
+	Private          bool   `json:"private,omitempty"`
+	SwarmKey         string `json:"swarmkey,omitempty"`
+	GenerateSwarmKey bool   `json:"generateswarmkey,omitempty"`
+
+	RepoPath  string   `json:"repo,omitempty"`
+	DBPath    string   `json:"dbpath,omitempty"`
+	Listen    []string `json:"listen,omitempty"`
+	Announce  []string `json:"announce,omitempty"`
+	Bootstrap []string `json:"bootstrap,omitempty"`
+	Routing   string   `json:"routing,omitempty"`
+	Profiles  []string `json:"profiles,omitempty"`
+	Offline   *bool    `json:"offline,omitempty"` //为nil时使用服务端配置的值
+
+	Identity string `json:"identity,omitempty"`
+}
+
+// BootInfo 启动后的实例信息
+type BootInfo struct {
+	Instance  string
+	PeerID    string
+	OrbitDBID string
+	Private   bool
+	Offline   bool
+}
+
+// Boot 启动实例，实例已经启动时返回它的信息
+func (c *Client) Boot(ctx context.Context, opts *BootOptions) (*BootInfo, error) {
+	if opts == nil {
+		opts = &BootOptions{}
+	}
+
+	data := map[string]string{}
+	if _, err := c.call(ctx, "/boot", opts, &data); err != nil {
+		return nil, err
+	}
+
+	private, _ := strconv.ParseBool(data["private"])
+	offline, _ := strconv.ParseBool(data["offline"])
+	return &BootInfo{
+		Instance:  data["instance"],
+		PeerID:    data["peerID"],
+		OrbitDBID: data["orbitdbID"],
+		Private:   private,
+		Offline:   offline,
+	}, nil
+}
+
+// Close 关闭实例
+func (c *Client) Close(ctx context.Context) error {
+	_, err := c.call(ctx, "/close", nil, nil)
+	return err
+}
+
+// DBInfo 实例内置数据库中保存的数据库信息
+type DBInfo struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Address    string   `json:"address"`
+	AddedAt    string   `json:"addat"`
+	Peers      []string `json:"peers"`
+	Recipients []string `json:"recipients,omitempty"`
+	Identity   string   `json:"identity,omitempty"`
+}
+
+// Programs 列出实例打开过的数据库，key为数据库地址
+func (c *Client) Programs(ctx context.Context) (map[string]DBInfo, error) {
+	raw := map[string][]byte{}
+	if _, err := c.call(ctx, "/programs", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	infos := make(map[string]DBInfo, len(raw))
+	for address, value := range raw {
+		info := DBInfo{}
+		if err := json.Unmarshal(value, &info); err != nil {
+			return nil, fmt.Errorf("decode db info %s: %w", address, err)
+		}
+		infos[address] = info
+	}
+	return infos, nil
+}
+
+// CreateOptions 创建数据库的参数，见 httpapi 的 createIn
+type CreateOptions struct {
+	Name      string   `json:"name"`
+	StoreType string   `json:"storetype"`
+	AccessIDs []string `json:"accessids,omitempty"`
+
+	ACType   string   `json:"actype,omitempty"`
+	AdminIDs []string `json:"adminids,omitempty"`
+
+	Recipients []string `json:"recipients,omitempty"`
+
+	Identity string `json:"identity,omitempty"`
+}
+
+// CreateDB 创建数据库，返回数据库地址
+func (c *Client) CreateDB(ctx context.Context, opts CreateOptions) (address string, err error) {
+	_, err = c.call(ctx, "/createdb", opts, &address)
+	return
+}
+
+// RemoveDB 删除数据库
+func (c *Client) RemoveDB(ctx context.Context, address string) error {
+	_, err := c.call(ctx, "/removedb", map[string]string{"address": address}, nil)
+	return err
+}
+
+// CloseDB 关闭数据库
+func (c *Client) CloseDB(ctx context.Context, address string) error {
+	_, err := c.call(ctx, "/closedb", map[string]string{"address": address}, nil)
+	return err
+}
+
+// Command 数据库命令，见 httpapi 的 commandIn
+type Command struct {
+	Address string      `json:"address"`
+	Method  string      `json:"method"`
+	Key     string      `json:"key,omitempty"`
+	Value   interface{} `json:"value,omitempty"`
+
+	OriginPeers []string `json:"originpeers,omitempty"`
+}
+
+// Command 执行数据库命令，把结果解析到 out，返回分页的下一页游标
+func (c *Client) Command(ctx context.Context, cmd Command, out interface{}) (next string, err error) {
+	return c.call(ctx, "/command", cmd, out)
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"d-channel/config"
+	"d-channel/httpapi"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 没有启动实例时，接口返回的 fail 要解析为 *Error
+func TestHTTPAPIErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := httptest.NewServer(httpapi.Handler(config.Default()))
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL)
+
+	calls := map[string]func() error{
+		"programs": func() error { _, err := c.Programs(ctx); return err },
+		"createdb": func() error {
+			_, err := c.CreateDB(ctx, CreateOptions{Name: "t", StoreType: STORETYPE_KV})
+			return err
+		},
+		"removedb": func() error { return c.RemoveDB(ctx, "/orbitdb/x/t") },
+		"closedb":  func() error { return c.CloseDB(ctx, "/orbitdb/x/t") },
+		"kv get":   func() error { _, err := c.KV("/orbitdb/x/t").Get(ctx, "k", new(string)); return err },
+		"close":    func() error { return c.Close(ctx) },
+	}
+	for name, call := range calls {
+		var e *Error
+		if err := call(); !errors.As(err, &e) || e.Message != MSG_FAIL {
+			t.Errorf("%s: expected fail error, got %v", name, err)
+		}
+	}
+
+	_, err := c.WithInstance("../alice").Programs(ctx)
+	var e *Error
+	if !errors.As(err, &e) || e.Message != MSG_ERROR {
+		t.Errorf("invalid instance name: expected error, got %v", err)
+	}
+}
+
+// 在临时目录中离线启动实例，通过 httpapi 走一遍数据库的完整流程
+func TestOfflineInstance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := httptest.NewServer(httpapi.Handler(config.Default()))
+	defer server.Close()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := New(server.URL + "/").WithInstance("alice")
+
+	info, err := c.Boot(ctx, &BootOptions{
+		RepoPath: filepath.Join(dir, "repo"),
+		DBPath:   filepath.Join(dir, "db"),
+		Offline:  config.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if info.Instance != "alice" || !info.Offline {
+		t.Fatalf("boot: %+v", info)
+	}
+
+	create := func(name, storeType string) string {
+		address, err := c.CreateDB(ctx, CreateOptions{Name: name, StoreType: storeType})
+		if err != nil || address == "" {
+			t.Fatalf("createdb %s: %q %v", name, address, err)
+		}
+		return address
+	}
+
+	//KV
+	kv := c.KV(create("kv", STORETYPE_KV))
+	if _, err = kv.Put(ctx, "k", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	out := struct{ N int }{}
+	if found, err := kv.Get(ctx, "k", &out); err != nil || !found || out.N != 1 {
+		t.Errorf("kv get: %v %v %+v", found, err, out)
+	}
+	if found, err := kv.Get(ctx, "missing", &out); err != nil || found {
+		t.Errorf("kv get missing: %v %v", found, err)
+	}
+
+	//Log 分页
+	logdb := c.Log(create("log", STORETYPE_LOG))
+	for _, v := range []string{"a", "b", "c"} {
+		if _, err = logdb.Add(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	amount := 2
+	values := []string{}
+	opts := ListOptions{Amount: &amount}
+	for {
+		ops, next, err := logdb.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := []string{}
+		for _, op := range ops {
+			var v string
+			if err = op.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			page = append(page, v)
+		}
+		values = append(page, values...)
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("log list: got %v, want %v", values, want)
+	}
+
+	//Docs
+	docs := c.Docs(create("docs", STORETYPE_DOCS))
+	for _, doc := range []map[string]interface{}{
+		{"_id": "1", "name": "alice", "age": 30},
+		{"_id": "2", "name": "bob", "age": 17},
+	} {
+		if _, err = docs.Put(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, _, err := docs.Query(ctx, Query{Filter: map[string]interface{}{"age": map[string]interface{}{"$gt": 18}}})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("docs query: %v %s", err, found)
+	}
+	doc := struct{ Name string }{}
+	if err = json.Unmarshal(found[0], &doc); err != nil || doc.Name != "alice" {
+		t.Errorf("docs query: %v %+v", err, doc)
+	}
+
+	//关闭和删除
+	if err = c.CloseDB(ctx, logdb.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.RemoveDB(ctx, kv.Address); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := c.Programs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := infos[kv.Address]; ok {
+		t.Error("removed db still in programs")
+	}
+	if _, ok := infos[logdb.Address]; !ok {
+		t.Error("closed db should stay in programs")
+	}
+}
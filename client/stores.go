@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// 命令的方法名称，与 httpapi 相同
+const (
+	METHOD_all    = "all"
+	METHOD_put    = "put"
+	METHOD_get    = "get"
+	METHOD_add    = "add"
+	METHOD_delete = "delete"
+	METHOD_query  = "query"
+	METHOD_list   = "list"
+)
+
+// Operation oplog中的一个操作，Value 为写入的json
+type Operation struct {
+	Op    string  `json:"op"`
+	Key   *string `json:"key"`
+	Value []byte  `json:"value"`
+	CID   string  `json:"cid"`
+}
+
+// Decode 把操作的值解析到 out
+func (op *Operation) Decode(out interface{}) error {
+	return json.Unmarshal(op.Value, out)
+}
+
+// KV keyvalue 数据库
+type KV struct {
+	c       *Client
+	Address string
+}
+
+// KV 按地址操作 keyvalue 数据库
+func (c *Client) KV(address string) *KV {
+	return &KV{c: c, Address: address}
+}
+
+func (s *KV) command(ctx context.Context, method, key string, value interface{}, out interface{}) error {
+	_, err := s.c.Command(ctx, Command{Address: s.Address, Method: method, Key: key, Value: value}, out)
+	return err
+}
+
+// Put 写入 value 的json
+func (s *KV) Put(ctx context.Context, key string, value interface{}) (*Operation, error) {
+	op := &Operation{}
+	return op, s.command(ctx, METHOD_put, key, value, op)
+}
+
+// Get 把key的值解析到 out，key不存在时返回false
+func (s *KV) Get(ctx context.Context, key string, out interface{}) (bool, error) {
+	var value []byte
+	if err := s.command(ctx, METHOD_get, key, nil, &value); err != nil {
+		return false, err
+	}
+	if value == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, out)
+}
+
+// Delete 删除key
+func (s *KV) Delete(ctx context.Context, key string) (*Operation, error) {
+	op := &Operation{}
+	return op, s.command(ctx, METHOD_delete, key, nil, op)
+}
+
+// All 所有key的值
+func (s *KV) All(ctx context.Context) (map[string]json.RawMessage, error) {
+	raw := map[string][]byte{}
+	if err := s.command(ctx, METHOD_all, "", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]json.RawMessage, len(raw))
+	for k, v := range raw {
+		all[k] = v
+	}
+	return all, nil
+}
+
+// Log eventlog 数据库
+type Log struct {
+	c       *Client
+	Address string
+}
+
+// Log 按地址操作 eventlog 数据库
+func (c *Client) Log(address string) *Log {
+	return &Log{c: c, Address: address}
+}
+
+// Add 追加 value 的json
+func (s *Log) Add(ctx context.Context, value interface{}) (*Operation, error) {
+	op := &Operation{}
+	_, err := s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_add, Value: value}, op)
+	return op, err
+}
+
+// Get 按entry的CID读取
+func (s *Log) Get(ctx context.Context, cid string) (*Operation, error) {
+	op := &Operation{}
+	_, err := s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_get, Key: cid}, op)
+	return op, err
+}
+
+// ListOptions 日志的范围查询参数，边界为entry的CID。
+// Amount 大于0时分页，Cursor 为上一页返回的 next
+type ListOptions struct {
+	GT      string `json:"gt,omitempty"`
+	GTE     string `json:"gte,omitempty"`
+	LT      string `json:"lt,omitempty"`
+	LTE     string `json:"lte,omitempty"`
+	Amount  *int   `json:"amount,omitempty"`
+	Reverse bool   `json:"reverse,omitempty"`
+	Cursor  string `json:"cursor,omitempty"`
+}
+
+// List 按范围列出日志，返回下一页的游标，没有下一页时为空
+func (s *Log) List(ctx context.Context, opts ListOptions) (ops []Operation, next string, err error) {
+	next, err = s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_list, Value: opts}, &ops)
+	return
+}
+
+// Docs docstore 数据库
+type Docs struct {
+	c       *Client
+	Address string
+}
+
+// Docs 按地址操作 docstore 数据库
+func (c *Client) Docs(address string) *Docs {
+	return &Docs{c: c, Address: address}
+}
+
+// Put 写入文档，文档必须有 _id 字段
+func (s *Docs) Put(ctx context.Context, doc interface{}) (*Operation, error) {
+	op := &Operation{}
+	_, err := s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_put, Value: doc}, op)
+	return op, err
+}
+
+// Get 按 _id 读取文档
+func (s *Docs) Get(ctx context.Context, key string) (docs []json.RawMessage, err error) {
+	_, err = s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_get, Key: key}, &docs)
+	return
+}
+
+// Delete 删除文档
+func (s *Docs) Delete(ctx context.Context, key string) (*Operation, error) {
+	op := &Operation{}
+	_, err := s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_delete, Key: key}, op)
+	return op, err
+}
+
+// Query 文档查询语句，见 httpapi 的 query
+type Query struct {
+	Filter map[string]interface{} `json:"filter,omitempty"`
+	Sort   []string               `json:"sort,omitempty"`
+	Skip   int                    `json:"skip,omitempty"`
+	Limit  int                    `json:"limit,omitempty"`
+	Fields []string               `json:"fields,omitempty"`
+	Cursor string                 `json:"cursor,omitempty"`
+}
+
+// Query 查询文档，返回下一页的游标，没有下一页时为空
+func (s *Docs) Query(ctx context.Context, q Query) (docs []json.RawMessage, next string, err error) {
+	next, err = s.c.Command(ctx, Command{Address: s.Address, Method: METHOD_query, Value: q}, &docs)
+	return
+}
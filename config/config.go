@@ -0,0 +1,176 @@
+// Package config 启动参数的配置文件。
+//
+// 参数的优先级从低到高为：
+//  1. 默认值（repo 和 dbpath 为 database.DEFAULT_PATH，端口为 8000）
+//  2. 配置文件（-c 指定，按扩展名解析 .toml、.yaml 或 .yml）
+//  3. 环境变量（DAPPPORT，只有端口）
+//  4. 命令行参数
+//  5. 命名实例在配置文件 instances.<name> 中的值（端口除外，只对该实例有效）
+//  6. /boot 请求中的字段（端口除外，只对本次启动有效）
+//
+// 高优先级的值为空时不覆盖低优先级的值；offline 没有设置时不覆盖，设置为false时也会覆盖。
+// listen、announce、bootstrap 只在启动时覆盖repo中的配置，不写入repo。
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DEFAULT_PORT = "8000"
+	DEFAULT_PATH = "default" //与 database.DEFAULT_PATH 相同，使用kubo和orbitdb的默认目录
+
+	PORT_ENV = "DAPPPORT"
+)
+
+// Config 启动参数
+type Config struct {
+	Port      string   `toml:"port" yaml:"port" json:"port"`                //http接口端口
+	RepoPath  string   `toml:"repo" yaml:"repo" json:"repo"`                //ipfs repo 目录
+	DBPath    string   `toml:"dbpath" yaml:"dbpath" json:"dbpath"`          //orbitdb 目录
+	Listen    []string `toml:"listen" yaml:"listen" json:"listen"`          //ipfs 监听地址，替换repo中的 Addresses.Swarm
+	Announce  []string `toml:"announce" yaml:"announce" json:"announce"`    //ipfs 对外公布的地址，替换repo中的 Addresses.Announce
+	Bootstrap []string `toml:"bootstrap" yaml:"bootstrap" json:"bootstrap"` //引导节点，替换repo中的 Bootstrap
+
+	Routing  string   `toml:"routing" yaml:"routing" json:"routing"`    //路由模式 client、server、auto 或 none
+	Profiles []string `toml:"profiles" yaml:"profiles" json:"profiles"` //新建repo时应用的kubo配置profile
+	Offline  *bool    `toml:"offline" yaml:"offline" json:"offline"`    //离线启动，为nil时不覆盖
+
+	Instances map[string]*Config `toml:"instances" yaml:"instances" json:"instances,omitempty"` //命名实例的参数，覆盖上面的值
+}
+
+// Default 默认参数
+func Default() *Config {
+	return &Config{
+		Port:     DEFAULT_PORT,
+		RepoPath: DEFAULT_PATH,
+		DBPath:   DEFAULT_PATH,
+	}
+}
+
+// Load 读取配置文件，按扩展名选择格式，有未知字段时返回错误
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(data)).DisallowUnknownFields().Decode(cfg)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	default:
+		err = fmt.Errorf("unsupported config file: %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Merge 用 o 中不为空的值覆盖 c，返回 c
+func (c *Config) Merge(o *Config) *Config {
+	if o == nil {
+		return c
+	}
+
+	if o.Port != "" {
+		c.Port = o.Port
+	}
+	if o.RepoPath != "" {
+		c.RepoPath = o.RepoPath
+	}
+	if o.DBPath != "" {
+		c.DBPath = o.DBPath
+	}
+	if o.Listen != nil {
+		c.Listen = o.Listen
+	}
+	if o.Announce != nil {
+		c.Announce = o.Announce
+	}
+	if o.Bootstrap != nil {
+		c.Bootstrap = o.Bootstrap
+	}
+	if o.Routing != "" {
+		c.Routing = o.Routing
+	}
+	if o.Profiles != nil {
+		c.Profiles = o.Profiles
+	}
+	if o.Offline != nil {
+		c.Offline = Bool(*o.Offline)
+	}
+	if o.Instances != nil {
+		c.Instances = o.Instances
+	}
+
+	return c
+}
+
+// Copy 复制一份，修改副本不影响原值
+func (c *Config) Copy() *Config {
+	n := *c
+	n.Listen = copyList(c.Listen)
+	n.Announce = copyList(c.Announce)
+	n.Bootstrap = copyList(c.Bootstrap)
+	n.Profiles = copyList(c.Profiles)
+	if c.Offline != nil {
+		n.Offline = Bool(*c.Offline)
+	}
+	return &n
+}
+
+// IsOffline 是否离线启动，没有设置时为false
+func (c *Config) IsOffline() bool {
+	return c.Offline != nil && *c.Offline
+}
+
+// Bool 返回 v 的指针，用于设置 Offline
+func Bool(v bool) *bool {
+	return &v
+}
+
+// 空列表和nil含义不同（空列表表示清空），复制时保留
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string{}, list...)
+}
+
+// ForInstance 命名实例的参数：复制一份，再用 instances 中同名的参数覆盖
+func (c *Config) ForInstance(name string) *Config {
+	n := c.Copy()
+	if o, ok := c.Instances[name]; ok {
+		n.Merge(o)
+		n.Port = c.Port
+	}
+	n.Instances = nil
+	return n
+}
+
+// SplitList 把命令行中逗号分隔的列表拆开，空字符串返回nil
+func SplitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
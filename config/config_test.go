@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	want := &Config{
+		Port:      "9000",
+		RepoPath:  "/data/ipfs",
+		DBPath:    "/data/orbitdb",
+		Listen:    []string{"/ip4/0.0.0.0/tcp/4001"},
+		Announce:  []string{"/ip4/1.2.3.4/tcp/4001"},
+		Bootstrap: []string{},
+		Routing:   "none",
+		Profiles:  []string{"test", "local-discovery"},
+		Offline:   Bool(true),
+	}
+
+	toml := writeFile(t, "d.toml", `
+port = "9000"
+repo = "/data/ipfs"
+dbpath = "/data/orbitdb"
+listen = ["/ip4/0.0.0.0/tcp/4001"]
+announce = ["/ip4/1.2.3.4/tcp/4001"]
+bootstrap = []
+routing = "none"
+profiles = ["test", "local-discovery"]
+offline = true
+`)
+	yaml := writeFile(t, "d.yml", `
+port: "9000"
+repo: /data/ipfs
+dbpath: /data/orbitdb
+listen: ["/ip4/0.0.0.0/tcp/4001"]
+announce: ["/ip4/1.2.3.4/tcp/4001"]
+bootstrap: []
+routing: none
+profiles: [test, local-discovery]
+offline: true
+`)
+
+	for _, path := range []string{toml, yaml} {
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: got %+v, want %+v", path, cfg, want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(writeFile(t, "d.json", `{}`)); err == nil {
+		t.Error("expected error for unsupported extension")
+	}
+	if _, err := Load(writeFile(t, "d.yaml", "unknown: 1\n")); err == nil {
+		t.Error("expected error for unknown yaml field")
+	}
+	if _, err := Load(writeFile(t, "d.toml", "unknown = 1\n")); err == nil {
+		t.Error("expected error for unknown toml field")
+	}
+	if _, err := Load(writeFile(t, "d.toml", "[instances.alice]\nrepo = \"/a\"\nofline = true\n")); err == nil {
+		t.Error("expected error for unknown toml field in an instance")
+	}
+}
+
+func TestMergePrecedence(t *testing.T) {
+	cfg := Default()
+	cfg.Merge(&Config{Port: "9000", RepoPath: "/file", Bootstrap: []string{"/file"}})
+	cfg.Merge(&Config{Port: "9100"})
+	cfg.Merge(&Config{RepoPath: "/flag", Offline: Bool(true)})
+	cfg.Merge(&Config{})
+
+	if cfg.Port != "9100" || cfg.RepoPath != "/flag" || cfg.DBPath != DEFAULT_PATH || !cfg.IsOffline() {
+		t.Errorf("unexpected merge result: %+v", cfg)
+	}
+
+	//明确设置为false时覆盖配置文件中的 offline
+	if online := cfg.Copy().Merge(&Config{Offline: Bool(false)}); online.IsOffline() {
+		t.Error("offline=false should override offline=true")
+	}
+	if !cfg.IsOffline() {
+		t.Error("copy changed the original offline")
+	}
+
+	// /boot 的覆盖只对副本生效
+	boot := cfg.Copy().Merge(&Config{Bootstrap: []string{}})
+	if len(boot.Bootstrap) != 0 || boot.Bootstrap == nil {
+		t.Errorf("empty bootstrap should clear the list: %#v", boot.Bootstrap)
+	}
+	if !reflect.DeepEqual(cfg.Bootstrap, []string{"/file"}) {
+		t.Errorf("copy changed the original: %#v", cfg.Bootstrap)
+	}
+}
+
+func TestForInstance(t *testing.T) {
+	cfg, err := Load(writeFile(t, "d.toml", `
+repo = "/data/ipfs"
+bootstrap = ["/base"]
+
+[instances.alice]
+port = "9999"
+repo = "/data/alice/ipfs"
+dbpath = "/data/alice/orbitdb"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = Default().Merge(cfg)
+
+	alice := cfg.ForInstance("alice")
+	if alice.RepoPath != "/data/alice/ipfs" || alice.DBPath != "/data/alice/orbitdb" {
+		t.Errorf("instance paths not applied: %+v", alice)
+	}
+	if alice.Port != DEFAULT_PORT || alice.Instances != nil {
+		t.Errorf("port and instances should not come from the instance: %+v", alice)
+	}
+	if !reflect.DeepEqual(alice.Bootstrap, []string{"/base"}) {
+		t.Errorf("base values should be kept: %#v", alice.Bootstrap)
+	}
+
+	bob := cfg.ForInstance("bob")
+	if bob.RepoPath != "/data/ipfs" || bob.DBPath != DEFAULT_PATH {
+		t.Errorf("unknown instance should use base values: %+v", bob)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if SplitList("") != nil {
+		t.Error("empty string should be nil")
+	}
+	got := SplitList(" /a, ,/b ")
+	if !reflect.DeepEqual(got, []string{"/a", "/b"}) {
+		t.Errorf("got %#v", got)
+	}
+}
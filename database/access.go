@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/iface"
+)
+
+// 数据库的访问控制类型
+const (
+	ACTYPE_IPFS    = "ipfs"    //写入列表在创建时确定，不能修改
+	ACTYPE_ORBITDB = "orbitdb" //写入列表保存在orbitdb中，管理员可以授权和撤销
+)
+
+// 访问控制中的权限
+const (
+	CAPABILITY_ADMIN = "admin"
+	CAPABILITY_WRITE = "write"
+)
+
+// ACL 数据库的访问控制列表，列表中为orbitdb身份ID，"*" 表示所有人
+type ACL struct {
+	Type  string   `json:"type"`
+	Admin []string `json:"admin"`
+	Write []string `json:"write"`
+}
+
+// 创建数据库时的访问控制参数，没有指定写入者或管理员时为创建者
+func (acl *ACL) access(ownID string) map[string][]string {
+	write := acl.Write
+	if len(write) == 0 {
+		write = []string{ownID}
+	}
+	access := map[string][]string{
+		CAPABILITY_WRITE: write,
+	}
+
+	if acl.Type == ACTYPE_ORBITDB {
+		admin := acl.Admin
+		if len(admin) == 0 {
+			admin = []string{ownID}
+		}
+		access[CAPABILITY_ADMIN] = admin
+	}
+
+	return access
+}
+
+// GetACL 读取数据库当前的访问控制列表
+func GetACL(db iface.Store) (*ACL, error) {
+	ac := db.AccessController()
+	if ac == nil {
+		return nil, fmt.Errorf("db has no access controller")
+	}
+
+	acl := &ACL{Type: ac.Type(), Admin: []string{}, Write: []string{}}
+
+	var err error
+	if acl.Write, err = ac.GetAuthorizedByRole(CAPABILITY_WRITE); err != nil {
+		return nil, err
+	}
+	if acl.Type == ACTYPE_ORBITDB {
+		if acl.Admin, err = ac.GetAuthorizedByRole(CAPABILITY_ADMIN); err != nil {
+			return nil, err
+		}
+	}
+
+	return acl, nil
+}
+
+// Grant 给身份授予权限，只有 orbitdb 类型的访问控制可以修改
+func Grant(ctx context.Context, db iface.Store, capability string, id string) error {
+	ac, err := mutableAC(db, capability)
+	if err != nil {
+		return err
+	}
+	return ac.Grant(ctx, capability, id)
+}
+
+// Revoke 撤销身份的权限，只有 orbitdb 类型的访问控制可以修改
+func Revoke(ctx context.Context, db iface.Store, capability string, id string) error {
+	ac, err := mutableAC(db, capability)
+	if err != nil {
+		return err
+	}
+	return ac.Revoke(ctx, capability, id)
+}
+
+func mutableAC(db iface.Store, capability string) (accesscontroller.Interface, error) {
+	switch capability {
+	case CAPABILITY_ADMIN, CAPABILITY_WRITE:
+	default:
+		return nil, fmt.Errorf("unknown capability: %s", capability)
+	}
+
+	ac := db.AccessController()
+	if ac == nil || ac.Type() != ACTYPE_ORBITDB {
+		return nil, fmt.Errorf("access controller of this db is immutable, create it with type '%s'", ACTYPE_ORBITDB)
+	}
+
+	return ac, nil
+}
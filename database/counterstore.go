@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/basestore"
+	"berty.tech/go-orbit-db/stores/operation"
+	icore "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// 计数器操作在oplog中的名称
+const OP_COUNTER = "COUNTER"
+
+// CounterStore 分布式计数器，每次 Inc 都是一条独立的oplog记录，
+// 数值为所有记录增量之和，因此不同节点并发写入不会互相覆盖
+type CounterStore interface {
+	iface.Store
+	Inc(ctx context.Context, amount int) (operation.Operation, error)
+	Value() int
+}
+
+type counterStore struct {
+	basestore.BaseStore
+}
+
+// newCounterStore 计数器数据库的构造函数，注册到orbitdb后用于创建和打开 counter 类型的数据库
+func newCounterStore(ipfs icore.CoreAPI, identity *identityprovider.Identity, addr address.Address, options *iface.NewStoreOptions) (iface.Store, error) {
+	store := &counterStore{}
+	options.Index = newCounterIndex
+
+	if err := store.InitBaseStore(ipfs, identity, addr, options); err != nil {
+		return nil, fmt.Errorf("unable to initialize counter store: %w", err)
+	}
+
+	return store, nil
+}
+
+func (s *counterStore) Type() string {
+	return STORETYPE_COUNTER
+}
+
+// Inc 按amount增加计数，amount可以为负数
+func (s *counterStore) Inc(ctx context.Context, amount int) (operation.Operation, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("amount can not be 0")
+	}
+
+	op := operation.NewOperation(nil, OP_COUNTER, []byte(strconv.Itoa(amount)))
+
+	e, err := s.AddOperation(ctx, op, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while adding operation: %w", err)
+	}
+
+	return operation.ParseOperation(e)
+}
+
+// Value 当前计数值
+func (s *counterStore) Value() int {
+	value, _ := s.Index().Get("").(int)
+	return value
+}
+
+// 计数器索引，每次从完整的oplog重新累加
+type counterIndex struct {
+	lock  sync.RWMutex
+	value int
+}
+
+func newCounterIndex(_ []byte) iface.StoreIndex {
+	return &counterIndex{}
+}
+
+func (i *counterIndex) Get(_ string) interface{} {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return i.value
+}
+
+func (i *counterIndex) UpdateIndex(oplog ipfslog.Log, _ []ipfslog.Entry) error {
+	value := counterValue(oplog.Values().Slice())
+
+	i.lock.Lock()
+	i.value = value
+	i.lock.Unlock()
+
+	return nil
+}
+
+// 累加entries中所有计数器操作的增量
+func counterValue(entries []ipfslog.Entry) int {
+	value := 0
+	for _, e := range entries {
+		op, err := operation.ParseOperation(e)
+		if err != nil || op.GetOperation() != OP_COUNTER {
+			continue
+		}
+
+		amount, err := strconv.Atoi(string(op.GetValue()))
+		if err != nil {
+			continue
+		}
+		value += amount
+	}
+	return value
+}
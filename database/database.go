@@ -2,46 +2,85 @@ package database
 
 import (
 	"context"
+	"d-channel/secret"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/keystore"
 	orbitdb "berty.tech/go-orbit-db"
 	"berty.tech/go-orbit-db/accesscontroller"
 	"berty.tech/go-orbit-db/iface"
 	"berty.tech/go-orbit-db/stores"
+	datastore "github.com/ipfs/go-datastore"
 
 	icore "github.com/ipfs/interface-go-ipfs-core"
 	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
-	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 const (
-	DEFAULT_PATH = "dafault"
+	DEFAULT_PATH = "default"
 	PROGRAMSDB   = "self.programs"
 	ORBITDIR     = "orbitdb"
 
-	STORETYPE_KV   = "keyvalue"
-	STORETYPE_DOCS = "docstore"
-	STORETYPE_LOG  = "eventlog"
+	STORETYPE_KV      = "keyvalue"
+	STORETYPE_DOCS    = "docstore"
+	STORETYPE_LOG     = "eventlog"
+	STORETYPE_COUNTER = "counter"
+	STORETYPE_FEED    = "feed"
 )
 
 type Instance struct {
-	lifecircle_ctx context.Context
-	Dir            string //orbitdb dirctory
-	Repo           string //ipfs repo path
+	lifecircle_ctx    context.Context
+	lifecircle_cancel context.CancelFunc //关闭实例时取消，结束所有后台任务
+	Dir               string             //orbitdb dirctory
+	Repo              string             //ipfs repo path
 
 	IPFSNode    *core.IpfsNode //ipfsnode
 	IPFSCoreAPI icore.CoreAPI  //ipfscoreapi
+	Offline     bool           //离线启动，数据库不在网络同步
 
-	OrbitDB  orbitdb.OrbitDB       //orbitdb object
-	Programs orbitdb.KeyValueStore // buildin db, local-only, to store other dbs information
+	OrbitDB      orbitdb.OrbitDB       //orbitdb object
+	Programs     orbitdb.KeyValueStore // buildin db, local-only, to store other dbs information
+	programsLock sync.Mutex            //修改 Programs 中的数据库信息时先读后写，同一时间只有一个写入
 
 	ConnectingDB map[string]iface.Store
+
+	indexLock  sync.RWMutex
+	keyIndexes map[string]*keyIndex //已连接KV数据库的有序key索引
+
+	casLocksLock sync.Mutex
+	casLocks     map[string]*sync.Mutex //条件写入的锁，每个数据库一个
+
+	dbLock    sync.Mutex
+	dbCancels map[string]context.CancelFunc //已连接数据库的后台任务，关闭数据库时取消
+
+	syncLock     sync.RWMutex
+	syncTrackers map[string]*syncTracker //已连接数据库的同步记录
+
+	Webhooks    orbitdb.KeyValueStore // buildin db, local-only, to store webhooks
+	hookLock    sync.Mutex
+	hookRunners map[string]*hookRunner //正在投递的回调
+
+	secretLock    sync.Mutex
+	sealedLock    sync.Mutex
+	sealedDBs     map[string]bool                //包含加密entry的数据库，不能再写入明文
+	sealedChecked map[string]map[string]struct{} //没有加密entry的数据库中已经检查过的entry
+	keys          *secret.SecretKeys             //本地age密钥，用于加密数据库
+	box           *secret.Box                    //口令解锁的数据密钥，为空表示密钥没有加密
+
+	Keystore     keystore.Interface    //orbitdb keystore，保存所有身份的密钥
+	keystoreDS   datastore.Datastore   //keystore的存储，导入身份时直接写入密钥
+	IdentitiesDB orbitdb.KeyValueStore // buildin db, local-only, to store named identities
+	identityLock sync.Mutex
+	identities   map[string]*identityprovider.Identity //已加载的命名身份
+	selected     string                                //创建和打开数据库时默认使用的身份
 }
 type DBInfo struct {
 	Name    string   `json:"name"`
@@ -49,74 +88,172 @@ type DBInfo struct {
 	Address string   `json:"address"`
 	AddedAt string   `json:"addat"`
 	Peers   []string `json:"peers"`
+
+	Recipients []string `json:"recipients,omitempty"` //加密数据库的age接收者，为空表示不加密
+	Identity   string   `json:"identity,omitempty"`   //打开数据库使用的身份名称
+
+	Sync *SyncStatus `json:"sync,omitempty"` //只在列出时填充，不保存
 }
 
-// BootInstance 启动一个实例
-func BootInstance(ctx context.Context, repoPath, dbpath string) (ins *Instance, err error) {
+// Options 启动实例的参数
+type Options struct {
+	RepoPath string //ipfs repo 目录，DEFAULT_PATH 或空为kubo默认目录
+	DBPath   string //orbitdb 目录，DEFAULT_PATH 或空为用户目录下的 orbitdb
 
-	ins = new(Instance)
-	ins.ConnectingDB = map[string]iface.Store{}
-	ins.lifecircle_ctx = ctx
+	Listen   []string //监听地址，不为nil时替换repo中的 Addresses.Swarm，只对本次启动有效
+	Announce []string //对外公布的地址，不为nil时替换repo中的 Addresses.Announce，只对本次启动有效
+
+	//不为空时，orbitdb keystore和本地age密钥用口令加密保存；已经加密过的实例必须提供口令
+	Passphrase string
 
-	if repoPath == DEFAULT_PATH {
+	Private          bool     //只加入私有网络，没有swarm key时拒绝启动
+	SwarmKey         string   //私有网络的预共享密钥，即 swarm.key 文件的内容
+	GenerateSwarmKey bool     //repo中没有swarm key时生成新的密钥
+	Bootstrap        []string //引导节点，不为nil时替换repo中的引导节点，只对本次启动有效；私有网络为nil时保留repo中的非公共引导节点
+
+	Routing  string   //路由模式，ROUTING_CLIENT（默认）、ROUTING_SERVER、ROUTING_AUTO 或 ROUTING_NONE
+	Profiles []string //新建repo时按顺序应用的kubo配置profile，如 server、lowpower、test、local-discovery
+	Offline  bool     //不连接网络，只读写本地数据库
+}
+
+// ResolvePaths 实例实际使用的ipfs repo和orbitdb目录，DEFAULT_PATH 或空为默认目录，返回清理后的绝对路径
+func ResolvePaths(repoPath, dbpath string) (string, string, error) {
+	var err error
+
+	if repoPath == "" || repoPath == DEFAULT_PATH {
 		repoPath, err = config.PathRoot()
 		if err != nil {
-			return
+			return "", "", err
 		}
 	}
 
-	if dbpath == DEFAULT_PATH {
+	if dbpath == "" || dbpath == DEFAULT_PATH {
 		dbpath, err = os.UserHomeDir()
 		if err != nil {
-			return
+			return "", "", err
 		}
 		dbpath = filepath.Join(dbpath, ORBITDIR)
 	}
 
+	if repoPath, err = filepath.Abs(repoPath); err != nil {
+		return "", "", err
+	}
+	if dbpath, err = filepath.Abs(dbpath); err != nil {
+		return "", "", err
+	}
+
+	return repoPath, dbpath, nil
+}
+
+// BootInstance 启动一个实例
+func BootInstance(ctx context.Context, opts *Options) (ins *Instance, err error) {
+
+	repoPath, dbpath, passphrase := opts.RepoPath, opts.DBPath, opts.Passphrase
+
+	ins = new(Instance)
+	ins.ConnectingDB = map[string]iface.Store{}
+	ins.syncTrackers = map[string]*syncTracker{}
+	ins.hookRunners = map[string]*hookRunner{}
+	ins.identities = map[string]*identityprovider.Identity{}
+	ins.dbCancels = map[string]context.CancelFunc{}
+	ins.lifecircle_ctx, ins.lifecircle_cancel = context.WithCancel(ctx)
+	ins.Offline = opts.Offline
+
+	repoPath, dbpath, err = ResolvePaths(repoPath, dbpath)
+	if err != nil {
+		return
+	}
+
 	ins.Dir = dbpath
 	ins.Repo = repoPath
 
+	//先解锁密钥，口令错误时不启动节点
+	if passphrase != "" {
+		ins.box, err = secret.Unlock(dbpath, passphrase)
+		if err != nil {
+			return
+		}
+	} else if secret.Locked(dbpath) {
+		err = secret.ErrLocked
+		return
+	}
+
 	if err = setupPlugins(repoPath); err != nil {
 		return
 	}
 
-	ins.IPFSNode, ins.IPFSCoreAPI, err = createNode(ctx, repoPath)
+	ins.IPFSNode, ins.IPFSCoreAPI, err = createNode(ctx, repoPath, opts, ins.box)
+	if err != nil {
+		return
+	}
+
+	//keystore由实例打开，以便创建其他命名身份
+	id := ins.IPFSNode.Identity.String()
+	ks, ds, err := openKeystore(ctx, keystorePath(ins.Dir, id), ins.box)
 	if err != nil {
 		return
 	}
+	ins.Keystore = ks
+	ins.keystoreDS = ds
 
 	ins.OrbitDB, err = orbitdb.NewOrbitDB(ctx, ins.IPFSCoreAPI, &orbitdb.NewOrbitDBOptions{
-		Directory: &ins.Dir,
+		ID:            &id,
+		Directory:     &ins.Dir,
+		Keystore:      ks,
+		CloseKeystore: ds.Close,
 	})
 	if err != nil {
 		return
 	}
 
+	//注册orbitdb没有内置的数据库类型
+	ins.OrbitDB.RegisterStoreType(STORETYPE_COUNTER, newCounterStore)
+	ins.OrbitDB.RegisterStoreType(STORETYPE_FEED, newFeedStore)
+
 	_, err = ins.GetProgramsDB(ctx)
 
 	return
 }
 
-func (ins *Instance) CreateDB(ctx context.Context, name string, storetype string, accesseIDs []string) (db iface.Store, err error) {
+// CreateDB 创建数据库，identity 为写入使用的身份名称，为空时使用当前选择的身份
+func (ins *Instance) CreateDB(ctx context.Context, name string, storetype string, acl ACL, identity string) (db iface.Store, err error) {
+
+	if name == PROGRAMSDB || name == WEBHOOKSDB || name == IDENTITIESDB {
+		err = fmt.Errorf("name can not be '%s'", name)
+		return
+	}
+
+	switch acl.Type {
+	case "":
+		acl.Type = ACTYPE_IPFS
+	case ACTYPE_IPFS, ACTYPE_ORBITDB:
+	default:
+		err = fmt.Errorf("unknown access controller type: %s", acl.Type)
+		return
+	}
 
-	if name == PROGRAMSDB {
-		err = fmt.Errorf("name can not be '%s'", PROGRAMSDB)
+	if identity == "" {
+		identity = ins.SelectedIdentity()
+	}
+	id, err := ins.Identity(ctx, identity)
+	if err != nil {
 		return
 	}
+
 	ac := &accesscontroller.CreateAccessControllerOptions{
-		Access: map[string][]string{
-			"write": accesseIDs,
-		},
+		Type:   acl.Type,
+		Access: acl.access(id.ID),
 	}
 
-	db, err = ins.OrbitDB.Create(ctx, name, storetype, &orbitdb.CreateDBOptions{
+	db, err = ins.OrbitDB.Create(ctx, name, storetype, ins.storeOptions(&orbitdb.CreateDBOptions{
 		AccessController: ac,
-	})
+		Identity:         id,
+	}))
 	if err != nil {
 		return
 	}
 
-	err = ins.initDB(ctx, db, []string{})
+	err = ins.initDB(ctx, db, []string{}, identity)
 	if err != nil {
 		return
 	}
@@ -124,9 +261,17 @@ func (ins *Instance) CreateDB(ctx context.Context, name string, storetype string
 	return
 }
 
-func (ins *Instance) OpenDB(ctx context.Context, address string, originPeers []string) (db iface.Store, err error) {
+// OpenDB 打开数据库，identity 为空时使用上次打开该数据库的身份或当前选择的身份
+func (ins *Instance) OpenDB(ctx context.Context, address string, originPeers []string, identity string) (db iface.Store, err error) {
 
-	db, err = ins.OrbitDB.Open(ctx, address, &orbitdb.CreateDBOptions{})
+	identity, id, err := ins.dbIdentity(ctx, address, identity)
+	if err != nil {
+		return
+	}
+
+	db, err = ins.OrbitDB.Open(ctx, address, ins.storeOptions(&orbitdb.CreateDBOptions{
+		Identity: id,
+	}))
 	if err != nil {
 		return
 	}
@@ -136,7 +281,7 @@ func (ins *Instance) OpenDB(ctx context.Context, address string, originPeers []s
 		return
 	}
 
-	err = ins.initDB(ctx, db, originPeers)
+	err = ins.initDB(ctx, db, originPeers, identity)
 	if err != nil {
 		return
 	}
@@ -158,7 +303,13 @@ func (ins *Instance) RemoveDB(ctx context.Context, address string) (err error) {
 			return
 		}
 		delete(ins.ConnectingDB, address)
+		ins.unwatchDB(address)
+		ins.untrackReplication(address)
+		ins.stopWebhooks(address)
 
+		ins.sealedLock.Lock()
+		delete(ins.sealedChecked, address)
+		ins.sealedLock.Unlock()
 	}
 
 	_, err = ins.Programs.Delete(ctx, address)
@@ -173,6 +324,9 @@ func (ins *Instance) CloseDB(ctx context.Context, address string) (err error) {
 			return
 		}
 		delete(ins.ConnectingDB, address)
+		ins.unwatchDB(address)
+		ins.untrackReplication(address)
+		ins.stopWebhooks(address)
 	}
 
 	return
@@ -180,13 +334,31 @@ func (ins *Instance) CloseDB(ctx context.Context, address string) (err error) {
 
 func (ins *Instance) Close() (err error) {
 
+	//结束所有数据库的后台任务（peer、同步记录、回调）
+	if ins.lifecircle_cancel != nil {
+		ins.lifecircle_cancel()
+	}
+
 	if ins.Programs != nil {
 		if err = ins.Programs.Close(); err != nil {
 			return
 		}
 	}
 
-	for _, db := range ins.ConnectingDB {
+	if ins.Webhooks != nil {
+		if err = ins.Webhooks.Close(); err != nil {
+			return
+		}
+	}
+
+	if ins.IdentitiesDB != nil {
+		if err = ins.IdentitiesDB.Close(); err != nil {
+			return
+		}
+	}
+
+	for address, db := range ins.ConnectingDB {
+		ins.stopWebhooks(address)
 		if err = db.Close(); err != nil {
 			return
 		}
@@ -215,9 +387,9 @@ func (ins *Instance) Close() (err error) {
 func (ins *Instance) GetProgramsDB(ctx context.Context) (program map[string][]byte, err error) {
 	localonly := true //programs 不在网络同步
 	if ins.Programs == nil && ins.OrbitDB != nil {
-		ins.Programs, err = ins.OrbitDB.KeyValue(ctx, PROGRAMSDB, &orbitdb.CreateDBOptions{
+		ins.Programs, err = ins.OrbitDB.KeyValue(ctx, PROGRAMSDB, ins.storeOptions(&orbitdb.CreateDBOptions{
 			LocalOnly: &localonly,
-		})
+		}))
 		if err != nil {
 			return
 		}
@@ -230,51 +402,90 @@ func (ins *Instance) GetProgramsDB(ctx context.Context) (program map[string][]by
 	return ins.Programs.All(), nil
 }
 
-func (ins *Instance) initDB(ctx context.Context, db iface.Store, originPeers []string) (err error) {
-
-	//如果没有保存在program，就保存进去
-	var value []byte
-	value, err = ins.Programs.Get(ctx, db.Address().String())
-	if err != nil || value == nil {
-		value, err = json.Marshal(DBInfo{
-			Name:    db.DBName(),
-			Type:    db.Type(),
-			Address: db.Address().String(),
-			AddedAt: time.Now().String(),
-			Peers:   originPeers,
-		})
-		if err != nil {
-			return err
-		}
-		_, err = ins.Programs.Put(ctx, db.Address().String(), value)
-		if err != nil {
-			return
-		}
+func (ins *Instance) initDB(ctx context.Context, db iface.Store, originPeers []string, identity string) (err error) {
+
+	//如果没有保存在program，就保存进去，否则记录新的身份，下次打开时使用
+	info, err := ins.saveDBInfo(ctx, db, originPeers, identity)
+	if err != nil {
+		return
 	}
 
 	//如果没有在已连接数据库里，就连接，并监听
 	_, ok := ins.ConnectingDB[db.Address().String()]
 	if !ok {
 		ins.ConnectingDB[db.Address().String()] = db
-		go ins.listenPeerEvent(db, value)
+		ins.markSealed(db.Address().String(), db.OpLog().Values().Slice())
+		dbctx := ins.watchDB(db.Address().String())
+		go ins.listenPeerEvent(dbctx, db, info.Peers)
+		go ins.trackReplication(dbctx, db)
+		if kv, ok := db.(iface.KeyValueStore); ok && db.Type() == STORETYPE_KV {
+			ins.indexKeys(dbctx, kv)
+		}
+		ins.startWebhooks(db)
 	}
 
 	return
 }
 
-func (ins *Instance) listenPeerEvent(db iface.Store, value []byte) {
-	var err error
-	var newPeerEvent event.Subscription
-	newPeerEvent, err = db.EventBus().Subscribe(new(stores.EventNewPeer))
+// 数据库后台任务的context，关闭数据库或实例时取消
+func (ins *Instance) watchDB(address string) context.Context {
+	ins.dbLock.Lock()
+	defer ins.dbLock.Unlock()
+
+	ctx, cancel := context.WithCancel(ins.lifecircle_ctx)
+	if old, ok := ins.dbCancels[address]; ok {
+		old()
+	}
+	ins.dbCancels[address] = cancel
+	return ctx
+}
+
+func (ins *Instance) unwatchDB(address string) {
+	ins.dbLock.Lock()
+	defer ins.dbLock.Unlock()
+
+	if cancel, ok := ins.dbCancels[address]; ok {
+		cancel()
+		delete(ins.dbCancels, address)
+	}
+}
+
+func (ins *Instance) saveDBInfo(ctx context.Context, db iface.Store, originPeers []string, identity string) (*DBInfo, error) {
+	ins.programsLock.Lock()
+	defer ins.programsLock.Unlock()
+
+	address := db.Address().String()
+	value, err := ins.Programs.Get(ctx, address)
+	info := &DBInfo{}
+	if err != nil || value == nil || json.Unmarshal(value, info) != nil {
+		info = &DBInfo{
+			Name:     db.DBName(),
+			Type:     db.Type(),
+			Address:  address,
+			AddedAt:  time.Now().String(),
+			Peers:    originPeers,
+			Identity: identity,
+		}
+	} else if info.Identity != identity {
+		info.Identity = identity
+	} else {
+		return info, nil
+	}
+
+	value, err = json.Marshal(info)
 	if err != nil {
-		return
+		return nil, err
 	}
+	_, err = ins.Programs.Put(ctx, address, value)
+	return info, err
+}
 
-	dbinfo := DBInfo{}
-	err = json.Unmarshal(value, &dbinfo)
+func (ins *Instance) listenPeerEvent(ctx context.Context, db iface.Store, peers []string) {
+	newPeerEvent, err := db.EventBus().Subscribe(new(stores.EventNewPeer))
 	if err != nil {
 		return
 	}
+	defer newPeerEvent.Close()
 
 	//尝试连接所有peer
 	go func(ctx context.Context, peers []string) {
@@ -298,24 +509,30 @@ func (ins *Instance) listenPeerEvent(db iface.Store, value []byte) {
 				return
 			}
 		}
-	}(ins.lifecircle_ctx, dbinfo.Peers)
+	}(ctx, peers)
 
+	address := db.Address().String()
 	for {
 		select {
-		case ev := <-newPeerEvent.Out():
+		case ev, ok := <-newPeerEvent.Out():
+			if !ok {
+				return
+			}
 
 			newPeerID := ev.(stores.EventNewPeer).Peer.String()
 
-			dbinfo.Peers = append(dbinfo.Peers, newPeerID)
-
-			value, err = json.Marshal(dbinfo)
-			if err != nil {
-				continue
-			}
-
-			ins.Programs.Put(ins.lifecircle_ctx, db.Address().String(), value)
+			//重新读取数据库信息，只添加peer，不覆盖打开之后修改的其他字段（如接收者列表、身份）
+			_ = ins.updateDBInfo(ctx, address, func(info *DBInfo) {
+				for _, p := range info.Peers {
+					if p == newPeerID {
+						return
+					}
+				}
+				info.Peers = append(info.Peers, newPeerID)
+			})
 
-		case <-ins.lifecircle_ctx.Done():
+		case <-ctx.Done():
+			return
 		}
 	}
 }
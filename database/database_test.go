@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"d-channel/secret"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	"berty.tech/go-orbit-db/stores/operation"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+// 内存中的 Programs，只实现用到的方法
+type memKV struct {
+	iface.KeyValueStore
+	lock sync.Mutex
+	m    map[string][]byte
+}
+
+func (kv *memKV) Put(ctx context.Context, key string, value []byte) (operation.Operation, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kv.m[key] = value
+	return nil, nil
+}
+
+func (kv *memKV) Get(ctx context.Context, key string) ([]byte, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.m[key], nil
+}
+
+type fakeAddress struct {
+	address.Address
+	s string
+}
+
+func (a fakeAddress) String() string { return a.s }
+
+// 只有事件总线和地址的数据库
+type fakeStore struct {
+	iface.Store
+	bus  event.Bus
+	addr fakeAddress
+}
+
+func (s *fakeStore) EventBus() event.Bus      { return s.bus }
+func (s *fakeStore) Address() address.Address { return s.addr }
+
+// 新peer加入时只添加peer，不覆盖打开数据库之后设置的接收者和身份
+func TestNewPeerKeepsDBInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const addr = "/orbitdb/bafy/test"
+	ins := &Instance{Programs: &memKV{m: map[string][]byte{}}}
+	store := &fakeStore{bus: eventbus.NewBus(), addr: fakeAddress{s: addr}}
+
+	value, _ := json.Marshal(DBInfo{Name: "test", Address: addr, Identity: "default"})
+	ins.Programs.Put(ctx, addr, value)
+
+	go ins.listenPeerEvent(ctx, store, nil)
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := []string{id.Recipient().String()}
+	if err = ins.SetRecipients(ctx, addr, recipients); err != nil {
+		t.Fatal(err)
+	}
+	err = ins.updateDBInfo(ctx, addr, func(info *DBInfo) { info.Identity = "alice" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter, err := store.bus.Emitter(new(stores.EventNewPeer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	//订阅在goroutine中完成，重复发送直到peer被记录
+	newPeer := peer.ID("new-peer")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_ = emitter.Emit(stores.EventNewPeer{Peer: newPeer})
+
+		info, err := ins.dbInfo(ctx, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Peers) > 0 {
+			if !reflect.DeepEqual(info.Peers, []string{newPeer.String()}) {
+				t.Errorf("peers: %v", info.Peers)
+			}
+			if !reflect.DeepEqual(info.Recipients, recipients) {
+				t.Errorf("recipients were overwritten: %v", info.Recipients)
+			}
+			if info.Identity != "alice" {
+				t.Errorf("identity was overwritten: %q", info.Identity)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new peer was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 数据库中有加密的entry时，没有接收者的节点不能写入明文
+func TestSealRejectsPlaintext(t *testing.T) {
+	ctx := context.Background()
+
+	const addr = "/orbitdb/bafy/sealed"
+	ins := &Instance{Programs: &memKV{m: map[string][]byte{}}}
+	value, _ := json.Marshal(DBInfo{Name: "sealed", Address: addr})
+	ins.Programs.Put(ctx, addr, value)
+
+	if out, err := ins.Seal(ctx, addr, []byte(`"plain"`)); err != nil || string(out) != `"plain"` {
+		t.Fatalf("plaintext db: %q %v", out, err)
+	}
+
+	sealed, _ := json.Marshal(map[string]string{"_id": "a", SECRET_FIELD: armor.Header + "\n..."})
+	if !isSealedValue(sealed) || !isSealedValue([]byte(armor.Header+"\n...")) {
+		t.Error("isSealedValue: sealed value not detected")
+	}
+	//包含 "$secret" 的普通值和文档不是密文
+	for _, plain := range []string{
+		`{"_id":"a"}`,
+		`"$secret"`,
+		`{"_id":"a","$secret":"not encrypted"}`,
+		`{"_id":"a","note":"$secret","$secret":"` + armor.Header + `"}`,
+	} {
+		if isSealedValue([]byte(plain)) {
+			t.Errorf("isSealedValue: %s is not sealed", plain)
+		}
+	}
+	doc := map[string]interface{}{"_id": "a", SECRET_FIELD: "not encrypted"}
+	if opened := ins.OpenDoc(doc); !reflect.DeepEqual(opened, doc) {
+		t.Errorf("OpenDoc: plaintext doc changed to %v", opened)
+	}
+	if out, err := ins.Seal(ctx, addr, []byte(`"$secret"`)); err != nil || string(out) != `"$secret"` {
+		t.Fatalf("plaintext value with $secret: %q %v", out, err)
+	}
+
+	ins.sealedDBs = map[string]bool{addr: true}
+	if _, err := ins.Seal(ctx, addr, []byte(`"plain"`)); err != ErrPlaintextWrite {
+		t.Errorf("Seal: expected ErrPlaintextWrite, got %v", err)
+	}
+	if _, err := ins.SealDoc(ctx, addr, map[string]interface{}{"_id": "a"}); err != ErrPlaintextWrite {
+		t.Errorf("SealDoc: expected ErrPlaintextWrite, got %v", err)
+	}
+	if err := ins.SetRecipients(ctx, addr, nil); err != ErrPlaintextWrite {
+		t.Errorf("SetRecipients: expected ErrPlaintextWrite, got %v", err)
+	}
+}
+
+// 只有配置的repo，记录写入磁盘的配置
+type memRepo struct {
+	repo.Repo
+	cfg   *config.Config
+	saved int
+}
+
+func (r *memRepo) Config() (*config.Config, error) { return r.cfg, nil }
+func (r *memRepo) SetConfig(cfg *config.Config) error {
+	r.saved++
+	r.cfg = cfg
+	return nil
+}
+
+func (r *memRepo) SetConfigKey(key string, value interface{}) error {
+	if key != "Identity.PrivKey" {
+		return fmt.Errorf("unexpected config key: %s", key)
+	}
+	r.saved++
+	r.cfg.Identity.PrivKey = value.(string)
+	return nil
+}
+
+// 有口令时节点私钥加密写回repo，没有口令不能读取
+func TestRepoIdentitySealed(t *testing.T) {
+	const plain = "CAESQNodePrivateKey"
+	disk := &memRepo{cfg: &config.Config{}}
+	disk.cfg.Identity.PrivKey = plain
+
+	if key, err := openRepoIdentity(disk, nil); err != nil || key != "" || disk.saved != 0 {
+		t.Fatalf("without passphrase: %q %v", key, err)
+	}
+
+	box, err := secret.Unlock(t.TempDir(), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		key, err := openRepoIdentity(disk, box)
+		if err != nil || key != plain {
+			t.Fatalf("open %d: %q %v", i, key, err)
+		}
+	}
+	if disk.saved != 1 || strings.Contains(disk.cfg.Identity.PrivKey, plain) {
+		t.Errorf("private key is not sealed on disk: %q", disk.cfg.Identity.PrivKey)
+	}
+
+	if _, err = openRepoIdentity(disk, nil); err != secret.ErrLocked {
+		t.Errorf("sealed key without passphrase: expected ErrLocked, got %v", err)
+	}
+}
+
+// 启动参数和私有网络对配置的修改只对本次启动有效，不写入repo
+func TestNodeConfigInMemory(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Bootstrap = append([]string{}, config.DefaultBootstrapAddresses...)
+	cfg.Addresses.Swarm = []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic"}
+	disk := &memRepo{cfg: cfg}
+
+	cases := []struct {
+		name    string
+		opts    *Options
+		private bool
+	}{
+		{"public", &Options{Listen: []string{"/ip4/127.0.0.1/tcp/0"}, Bootstrap: []string{}}, false},
+		{"private", &Options{}, true},
+	}
+	for _, tc := range cases {
+		r, err := nodeConfig(disk, tc.opts, tc.private, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		boot, err := r.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(boot.Bootstrap) != 0 {
+			t.Errorf("%s: boot config should have no public bootstrap peers: %v", tc.name, boot.Bootstrap)
+		}
+	}
+
+	if disk.saved != 0 {
+		t.Errorf("config was written to the repo %d times", disk.saved)
+	}
+	if !reflect.DeepEqual(disk.cfg.Bootstrap, config.DefaultBootstrapAddresses) || len(disk.cfg.Addresses.Swarm) != 2 {
+		t.Errorf("repo config was changed: %+v", disk.cfg)
+	}
+
+	if r, _ := nodeConfig(disk, &Options{}, false, ""); r != repo.Repo(disk) {
+		t.Error("repo without changes should be used as is")
+	}
+}
@@ -0,0 +1,307 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"d-channel/secret"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/stores/operation"
+	"filippo.io/age"
+)
+
+// 加密文档中保存密文的字段，文档只保留 _id 和该字段
+const SECRET_FIELD = "$secret"
+
+// 无法解密的数据用这个标记代替，文档中标记字段为true
+const UNDECRYPTABLE_FIELD = "$undecryptable"
+
+// Undecryptable 本地密钥不能解密时返回的值
+var Undecryptable = []byte(`{"` + UNDECRYPTABLE_FIELD + `":true}`)
+
+// ErrPlaintextWrite 数据库中已有加密的entry，但本节点没有设置接收者
+var ErrPlaintextWrite = errors.New("db contains encrypted entries, set recipients before writing")
+
+// 本地age密钥，第一次使用时从orbitdb目录读取或生成
+func (ins *Instance) secretKeys() (*secret.SecretKeys, error) {
+	ins.secretLock.Lock()
+	defer ins.secretLock.Unlock()
+
+	if ins.keys == nil {
+		keys, err := secret.LoadKeys(ins.Dir, ins.box)
+		if err != nil {
+			return nil, err
+		}
+		ins.keys = keys
+	}
+
+	return ins.keys, nil
+}
+
+// Recipient 本地age公钥，其他节点把它加入接收者列表后，本节点才能读取加密数据库
+func (ins *Instance) Recipient() (string, error) {
+	keys, err := ins.secretKeys()
+	if err != nil {
+		return "", err
+	}
+	return keys.RecipientString(), nil
+}
+
+// SetRecipients 设置数据库的接收者列表，之后通过本节点写入的值都会加密。
+// 接收者列表只保存在本地，数据库中有加密的entry后，没有接收者的节点不能再写入，
+// 列表也不能再设置为空
+func (ins *Instance) SetRecipients(ctx context.Context, address string, recipients []string) error {
+	if _, err := secret.ParseRecipients(recipients); err != nil {
+		return err
+	}
+	if len(recipients) == 0 && ins.hasSealedEntries(address) {
+		return ErrPlaintextWrite
+	}
+
+	return ins.updateDBInfo(ctx, address, func(info *DBInfo) {
+		info.Recipients = recipients
+	})
+}
+
+// Recipients 数据库的接收者列表，不加密的数据库返回空
+func (ins *Instance) Recipients(ctx context.Context, address string) ([]string, error) {
+	info, err := ins.dbInfo(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return info.Recipients, nil
+}
+
+func (ins *Instance) dbInfo(ctx context.Context, address string) (*DBInfo, error) {
+	value, err := ins.Programs.Get(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("db not found: %s", address)
+	}
+
+	info := &DBInfo{}
+	if err = json.Unmarshal(value, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// 重新读取数据库信息，修改后保存。所有修改都通过这里，不会覆盖其他地方的修改
+func (ins *Instance) updateDBInfo(ctx context.Context, address string, update func(info *DBInfo)) error {
+	ins.programsLock.Lock()
+	defer ins.programsLock.Unlock()
+
+	info, err := ins.dbInfo(ctx, address)
+	if err != nil {
+		return err
+	}
+	update(info)
+
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	_, err = ins.Programs.Put(ctx, address, value)
+	return err
+}
+
+// Seal 按数据库的接收者列表加密数据，本地公钥总是包含在内。数据库不加密时原样返回，
+// 没有接收者但数据库中已有加密的entry时返回 ErrPlaintextWrite
+func (ins *Instance) Seal(ctx context.Context, address string, data []byte) ([]byte, error) {
+	list, err := ins.Recipients(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		if ins.hasSealedEntries(address) {
+			return nil, ErrPlaintextWrite
+		}
+		return data, nil
+	}
+
+	recipients, err := secret.ParseRecipients(list)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ins.secretKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	out := &bytes.Buffer{}
+	err = secret.Encrypt(append([]age.Recipient{keys.Recipient}, recipients...), bytes.NewReader(data), out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Open 解密数据，不是密文时原样返回，本地密钥不能解密时返回 Undecryptable
+func (ins *Instance) Open(data []byte) []byte {
+	if !secret.IsEncrypted(data) {
+		return data
+	}
+
+	keys, err := ins.secretKeys()
+	if err != nil {
+		return Undecryptable
+	}
+
+	out := &bytes.Buffer{}
+	if err = secret.Decrypt(keys.Identities, bytes.NewReader(data), out); err != nil {
+		return Undecryptable
+	}
+	return out.Bytes()
+}
+
+// SealDoc 加密docstore文档，加密后的文档只有 _id 和 $secret 两个字段，
+// 因此加密数据库的 _id 是明文
+func (ins *Instance) SealDoc(ctx context.Context, address string, doc interface{}) (interface{}, error) {
+	list, err := ins.Recipients(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		if ins.hasSealedEntries(address) {
+			return nil, ErrPlaintextWrite
+		}
+		return doc, nil
+	}
+
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("document must be an object")
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := ins.Seal(ctx, address, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"_id": m["_id"], SECRET_FIELD: string(sealed)}, nil
+}
+
+// SealedDoc 判断是否是 SealDoc 加密后的文档：只有 _id 和 $secret 两个字段，且 $secret 是age密文。
+// 返回文档的 _id 和密文
+func SealedDoc(doc interface{}) (id interface{}, sealed string, ok bool) {
+	m, ok := doc.(map[string]interface{})
+	if !ok || len(m) != 2 {
+		return nil, "", false
+	}
+	id, hasID := m["_id"]
+	sealed, ok = m[SECRET_FIELD].(string)
+	if !hasID || !ok || !secret.IsEncrypted([]byte(sealed)) {
+		return nil, "", false
+	}
+	return id, sealed, true
+}
+
+// OpenDoc 解密docstore文档，不是加密文档时原样返回
+func (ins *Instance) OpenDoc(doc interface{}) interface{} {
+	id, sealed, ok := SealedDoc(doc)
+	if !ok {
+		return doc
+	}
+
+	var out interface{}
+	if err := json.Unmarshal(ins.Open([]byte(sealed)), &out); err != nil {
+		return map[string]interface{}{"_id": id, UNDECRYPTABLE_FIELD: true}
+	}
+	if opened, ok := out.(map[string]interface{}); ok && opened[UNDECRYPTABLE_FIELD] == true {
+		opened["_id"] = id
+	}
+	return out
+}
+
+// 记录包含加密entry的数据库。加密要求随数据一起传播：其他节点或重建目录后的本节点
+// 打开或同步到加密的entry后，没有设置接收者时拒绝写入明文。
+// 检查过的entry记录下来，之后不再解析
+func (ins *Instance) markSealed(address string, entries []ipfslog.Entry) {
+	ins.sealedLock.Lock()
+	defer ins.sealedLock.Unlock()
+
+	if ins.sealedDBs[address] {
+		return
+	}
+	if ins.sealedDBs == nil {
+		ins.sealedDBs = map[string]bool{}
+	}
+	if ins.sealedChecked == nil {
+		ins.sealedChecked = map[string]map[string]struct{}{}
+	}
+	checked, ok := ins.sealedChecked[address]
+	if !ok {
+		checked = map[string]struct{}{}
+		ins.sealedChecked[address] = checked
+	}
+
+	for _, e := range entries {
+		hash := e.GetHash().String()
+		if _, ok := checked[hash]; ok {
+			continue
+		}
+		checked[hash] = struct{}{}
+
+		op, err := operation.ParseOperation(e)
+		if err != nil {
+			continue
+		}
+
+		values := [][]byte{op.GetValue()}
+		for _, doc := range op.GetDocs() {
+			values = append(values, doc.Value)
+		}
+		for _, v := range values {
+			if isSealedValue(v) {
+				ins.sealedDBs[address] = true
+				delete(ins.sealedChecked, address)
+				return
+			}
+		}
+	}
+}
+
+// 写入明文前调用：同步事件是异步处理的，先检查oplog中还没有检查过的entry，
+// 避免同步到加密entry之后、事件处理之前写入明文
+func (ins *Instance) hasSealedEntries(address string) bool {
+	if ins.isSealedDB(address) {
+		return true
+	}
+	if db, ok := ins.ConnectingDB[address]; ok {
+		ins.markSealed(address, db.OpLog().Values().Slice())
+	}
+	return ins.isSealedDB(address)
+}
+
+func (ins *Instance) isSealedDB(address string) bool {
+	ins.sealedLock.Lock()
+	defer ins.sealedLock.Unlock()
+	return ins.sealedDBs[address]
+}
+
+// 加密的值或加密后的文档json，只包含 "$secret" 字符串的普通值不算
+func isSealedValue(v []byte) bool {
+	if secret.IsEncrypted(v) {
+		return true
+	}
+	if !bytes.Contains(v, []byte(SECRET_FIELD)) {
+		return false
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return false
+	}
+	_, _, ok := SealedDoc(doc)
+	return ok
+}
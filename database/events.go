@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+)
+
+// 推送给订阅者的事件类型
+const (
+	EVENT_WRITE      = "write"      //本节点写入
+	EVENT_REPLICATED = "replicated" //从其他节点同步到新的entry
+	EVENT_READY      = "ready"      //数据库加载完成
+	EVENT_NEWPEER    = "newpeer"    //发现新的节点
+	EVENT_LAGGED     = "lagged"     //订阅者处理太慢，Dropped 个事件被丢弃，需要重新读取数据
+)
+
+// 订阅者处理不过来时，最多缓存的事件数量，超出的事件会被丢弃
+const eventBufferSize = 64
+
+// DBEvent 数据库事件，Entries 为本次事件涉及的entry
+type DBEvent struct {
+	Type    string       `json:"type"`
+	Address string       `json:"address"`
+	Entries []*EntryInfo `json:"entries,omitempty"`
+	Peer    string       `json:"peer,omitempty"`
+	Dropped int          `json:"dropped,omitempty"`
+}
+
+// SubscribeDB 订阅数据库的 write、replicated、ready、newpeer 事件，ctx结束后返回的channel会关闭。
+// 订阅者处理太慢时丢弃事件，不会阻塞数据库；缓存有空位后先发送一个 lagged 事件，
+// 在它之前丢弃的事件都计入 Dropped
+func SubscribeDB(ctx context.Context, db iface.Store) (<-chan DBEvent, error) {
+	sub, err := db.EventBus().Subscribe([]interface{}{
+		new(stores.EventWrite),
+		new(stores.EventReplicated),
+		new(stores.EventReady),
+		new(stores.EventNewPeer),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan DBEvent, eventBufferSize)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+
+		dropped := 0
+		for {
+			//有丢弃的事件时，缓存有空位就发送 lagged
+			var lagged chan<- DBEvent
+			if dropped > 0 {
+				lagged = out
+			}
+
+			select {
+			case lagged <- DBEvent{Type: EVENT_LAGGED, Address: db.Address().String(), Dropped: dropped}:
+				dropped = 0
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				ev, ok := toDBEvent(db, e)
+				if !ok {
+					continue
+				}
+				//lagged 发送之前的事件也丢弃，保证客户端收到的事件顺序不乱
+				if dropped > 0 {
+					dropped++
+					continue
+				}
+				select {
+				case out <- ev:
+				default:
+					dropped++
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func toDBEvent(db iface.Store, e interface{}) (ev DBEvent, ok bool) {
+	ev.Address = db.Address().String()
+
+	switch e := e.(type) {
+	case stores.EventWrite:
+		ev.Type = EVENT_WRITE
+		if e.Entry != nil {
+			ev.Entries = []*EntryInfo{NewEntryInfo(e.Entry)}
+		}
+	case stores.EventReplicated:
+		ev.Type = EVENT_REPLICATED
+		for _, entry := range e.Entries {
+			ev.Entries = append(ev.Entries, NewEntryInfo(entry))
+		}
+	case stores.EventReady:
+		ev.Type = EVENT_READY
+		for _, entry := range e.Heads {
+			ev.Entries = append(ev.Entries, NewEntryInfo(entry))
+		}
+	case stores.EventNewPeer:
+		ev.Type = EVENT_NEWPEER
+		ev.Peer = e.Peer.String()
+	default:
+		return ev, false
+	}
+
+	return ev, true
+}
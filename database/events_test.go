@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"berty.tech/go-orbit-db/stores"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+// 订阅者不读取时事件被丢弃，之后收到 lagged 事件，其中的 Dropped 与收到的事件合计为发送的总数
+func TestSubscribeLagged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &fakeStore{bus: eventbus.NewBus(), addr: fakeAddress{s: "/orbitdb/bafy/events"}}
+	events, err := SubscribeDB(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter, err := store.bus.Emitter(new(stores.EventNewPeer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	const total = eventBufferSize + 36
+	for i := 0; i < total; i++ {
+		if err = emitter.Emit(stores.EventNewPeer{Peer: peer.ID("peer")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received, dropped, lagged := 0, 0, false
+	timeout := time.After(5 * time.Second)
+	for received+dropped < total {
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case EVENT_NEWPEER:
+				received++
+			case EVENT_LAGGED:
+				lagged = true
+				dropped += ev.Dropped
+			}
+		case <-timeout:
+			t.Fatalf("received %d, dropped %d of %d events", received, dropped, total)
+		}
+	}
+
+	if !lagged {
+		t.Error("expected a lagged event")
+	}
+}
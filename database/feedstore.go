@@ -0,0 +1,262 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-orbit-db/address"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/basestore"
+	"berty.tech/go-orbit-db/stores/operation"
+	"github.com/ipfs/go-cid"
+	icore "github.com/ipfs/interface-go-ipfs-core"
+)
+
+// feed 操作在oplog中的名称
+const (
+	OP_FEED_ADD = "ADD"
+	OP_FEED_DEL = "DEL"
+)
+
+// FeedStore 可删除条目的日志数据库，条目用添加时的entry CID标识
+type FeedStore interface {
+	iface.Store
+	Add(ctx context.Context, data []byte) (operation.Operation, error)
+	Get(ctx context.Context, c cid.Cid) (operation.Operation, error)
+	Remove(ctx context.Context, c cid.Cid) (operation.Operation, error)
+	List(ctx context.Context, options *iface.StreamOptions) ([]operation.Operation, error)
+}
+
+type feedStore struct {
+	basestore.BaseStore
+}
+
+// newFeedStore feed数据库的构造函数
+func newFeedStore(ipfs icore.CoreAPI, identity *identityprovider.Identity, addr address.Address, options *iface.NewStoreOptions) (iface.Store, error) {
+	store := &feedStore{}
+	options.Index = newFeedIndex
+
+	if err := store.InitBaseStore(ipfs, identity, addr, options); err != nil {
+		return nil, fmt.Errorf("unable to initialize feed store: %w", err)
+	}
+
+	return store, nil
+}
+
+func (s *feedStore) Type() string {
+	return STORETYPE_FEED
+}
+
+// Add 添加一个条目
+func (s *feedStore) Add(ctx context.Context, data []byte) (operation.Operation, error) {
+	op := operation.NewOperation(nil, OP_FEED_ADD, data)
+
+	e, err := s.AddOperation(ctx, op, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while adding operation: %w", err)
+	}
+
+	return operation.ParseOperation(e)
+}
+
+// Get 按CID获取一个未被删除的条目
+func (s *feedStore) Get(ctx context.Context, c cid.Cid) (operation.Operation, error) {
+	e, ok := s.Index().Get(c.String()).(ipfslog.Entry)
+	if !ok || e == nil {
+		return nil, fmt.Errorf("entry not found: %s", c.String())
+	}
+
+	return operation.ParseOperation(e)
+}
+
+// Remove 删除一个条目，删除操作本身也会写入oplog
+func (s *feedStore) Remove(ctx context.Context, c cid.Cid) (operation.Operation, error) {
+	if _, err := s.Get(ctx, c); err != nil {
+		return nil, err
+	}
+
+	key := c.String()
+	op := operation.NewOperation(&key, OP_FEED_DEL, nil)
+
+	e, err := s.AddOperation(ctx, op, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while deleting operation: %w", err)
+	}
+
+	return operation.ParseOperation(e)
+}
+
+// List 按范围列出条目，范围规则与 EventLogStore.List 相同
+func (s *feedStore) List(ctx context.Context, options *iface.StreamOptions) ([]operation.Operation, error) {
+	index, ok := s.Index().(*feedIndex)
+	if !ok {
+		return nil, fmt.Errorf("unexpected feed index")
+	}
+
+	entries, err := index.list(options)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]operation.Operation, 0, len(entries))
+	for _, e := range entries {
+		op, err := operation.ParseOperation(e)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// rangeEntries 从按时间排序的entries中截取 gt/gte/lt/lte 范围内的条目，
+// 有下界时从下界开始取 Amount 条，否则取上界之前最新的 Amount 条，Amount 为空或-1时取全部。
+// positions 是oplog中每个条目的位置，边界按它在oplog中的位置确定，已被删除的条目也可以作为边界
+func rangeEntries(entries []ipfslog.Entry, positions map[string]int, options *iface.StreamOptions) ([]ipfslog.Entry, error) {
+	if options == nil {
+		options = &iface.StreamOptions{}
+	}
+
+	//第一个在边界之后（after 为false时包括边界）的条目
+	bound := func(c *cid.Cid, after bool) (int, error) {
+		p, ok := positions[c.String()]
+		if !ok {
+			return 0, fmt.Errorf("entry not found: %s", c.String())
+		}
+		return sort.Search(len(entries), func(i int) bool {
+			q := positions[entries[i].GetHash().String()]
+			return q > p || (!after && q == p)
+		}), nil
+	}
+
+	start, end := 0, len(entries)
+	var err error
+
+	switch {
+	case options.GT != nil:
+		start, err = bound(options.GT, true)
+	case options.GTE != nil:
+		start, err = bound(options.GTE, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case options.LT != nil:
+		end, err = bound(options.LT, false)
+	case options.LTE != nil:
+		end, err = bound(options.LTE, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if start >= end {
+		return []ipfslog.Entry{}, nil
+	}
+	entries = entries[start:end]
+
+	if options.Amount != nil && *options.Amount > -1 && *options.Amount < len(entries) {
+		if options.GT != nil || options.GTE != nil {
+			entries = entries[:*options.Amount]
+		} else {
+			entries = entries[len(entries)-*options.Amount:]
+		}
+	}
+
+	return entries, nil
+}
+
+// feed索引，保存未被删除的ADD条目，按oplog顺序排列，以及oplog中全部条目的位置
+type feedIndex struct {
+	lock      sync.RWMutex
+	entries   []ipfslog.Entry
+	positions map[string]int
+}
+
+func newFeedIndex(_ []byte) iface.StoreIndex {
+	return &feedIndex{}
+}
+
+// Get key为空时返回全部条目，否则按CID字符串返回单个条目
+func (i *feedIndex) Get(key string) interface{} {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	if key == "" {
+		entries := make([]ipfslog.Entry, len(i.entries))
+		copy(entries, i.entries)
+		return entries
+	}
+
+	for _, e := range i.entries {
+		if e.GetHash().String() == key {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// 按范围列出条目
+func (i *feedIndex) list(options *iface.StreamOptions) ([]ipfslog.Entry, error) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	entries, err := rangeEntries(i.entries, i.positions, options)
+	if err != nil {
+		return nil, err
+	}
+
+	//返回副本，索引更新时不影响调用者
+	return append([]ipfslog.Entry{}, entries...), nil
+}
+
+func (i *feedIndex) UpdateIndex(oplog ipfslog.Log, _ []ipfslog.Entry) error {
+	values := oplog.Values().Slice()
+	entries := feedEntries(values)
+
+	positions := make(map[string]int, len(values))
+	for p, e := range values {
+		positions[e.GetHash().String()] = p
+	}
+
+	i.lock.Lock()
+	i.entries = entries
+	i.positions = positions
+	i.lock.Unlock()
+
+	return nil
+}
+
+// 从按顺序排列的entries中找出未被删除的ADD条目
+func feedEntries(values []ipfslog.Entry) []ipfslog.Entry {
+	removed := map[string]struct{}{}
+	for _, e := range values {
+		op, err := operation.ParseOperation(e)
+		if err != nil || op.GetOperation() != OP_FEED_DEL || op.GetKey() == nil {
+			continue
+		}
+		removed[*op.GetKey()] = struct{}{}
+	}
+
+	entries := []ipfslog.Entry{}
+	for _, e := range values {
+		op, err := operation.ParseOperation(e)
+		if err != nil || op.GetOperation() != OP_FEED_ADD {
+			continue
+		}
+		if _, ok := removed[e.GetHash().String()]; ok {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/iface"
+	"github.com/ipfs/go-cid"
+)
+
+// 只有hash的条目
+type hashEntry struct {
+	ipfslog.Entry
+	hash cid.Cid
+}
+
+func (e *hashEntry) GetHash() cid.Cid {
+	return e.hash
+}
+
+func TestRangeEntriesRemovedBound(t *testing.T) {
+	prefix := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}
+
+	//oplog中有5个条目，位置1和3的条目已被删除
+	var log []cid.Cid
+	positions := map[string]int{}
+	for p, data := range []string{"a", "b", "c", "d", "e"} {
+		c, err := prefix.Sum([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, c)
+		positions[c.String()] = p
+	}
+	entries := []ipfslog.Entry{&hashEntry{hash: log[0]}, &hashEntry{hash: log[2]}, &hashEntry{hash: log[4]}}
+
+	amount := 1
+	cases := []struct {
+		options *iface.StreamOptions
+		want    []int
+	}{
+		{nil, []int{0, 2, 4}},
+		{&iface.StreamOptions{GT: &log[1]}, []int{2, 4}},
+		{&iface.StreamOptions{GTE: &log[2]}, []int{2, 4}},
+		{&iface.StreamOptions{LT: &log[3]}, []int{0, 2}},
+		{&iface.StreamOptions{LTE: &log[3], Amount: &amount}, []int{2}},
+		{&iface.StreamOptions{GT: &log[1], LT: &log[3]}, []int{2}},
+		{&iface.StreamOptions{GT: &log[3], LT: &log[1]}, []int{}},
+	}
+
+	for i, c := range cases {
+		got, err := rangeEntries(entries, positions, c.options)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%d: got %d entries, want %v", i, len(got), c.want)
+		}
+		for j, p := range c.want {
+			if !got[j].GetHash().Equals(log[p]) {
+				t.Errorf("%d: entry %d is not log[%d]", i, j, p)
+			}
+		}
+	}
+
+	unknown, _ := prefix.Sum([]byte("x"))
+	if _, err := rangeEntries(entries, positions, &iface.StreamOptions{GT: &unknown}); err == nil {
+		t.Error("expected error for an entry not in the log")
+	}
+}
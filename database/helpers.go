@@ -2,12 +2,14 @@ package database
 
 import (
 	"context"
+	"d-channel/secret"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 
 	// files "github.com/ipfs/go-ipfs-files"
 
@@ -16,36 +18,46 @@ import (
 	config "github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
-	"github.com/ipfs/kubo/core/node/libp2p"
 	"github.com/ipfs/kubo/plugin/loader"
+	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/mitchellh/mapstructure"
 	"go.uber.org/zap"
 )
 
+// kubo的插件是进程全局的，多个实例只加载一次，使用第一个启动的实例repo中的插件
+var (
+	pluginsOnce sync.Once
+	pluginsErr  error
+)
+
 func setupPlugins(path string) error {
+	pluginsOnce.Do(func() {
+		pluginsErr = loadPlugins(path)
+	})
+	return pluginsErr
+}
+
+func loadPlugins(path string) error {
 
 	plugins, err := loader.NewPluginLoader(filepath.Join(path, "plugins"))
 	if err != nil {
-		err = fmt.Errorf("error loading plugins: %s", err)
-		return err
+		return fmt.Errorf("error loading plugins: %w", err)
 	}
 
 	if err = plugins.Initialize(); err != nil {
-		err = fmt.Errorf("error initializing plugins: %s", err)
-		return err
+		return fmt.Errorf("error initializing plugins: %w", err)
 	}
 
 	if err = plugins.Inject(); err != nil {
-		err = fmt.Errorf("error Inject plugins: %s", err)
-		log.Println(err.Error())
+		return fmt.Errorf("error injecting plugins: %w", err)
 	}
 
 	return nil
 }
-func createRepo(repoPath string) error {
+func createRepo(repoPath string, profiles []string) error {
 
-	log.Printf("created new repo\n")
+	log.Printf("creating new repo %s", repoPath)
 	var cfg *config.Config
 	var err error
 
@@ -61,39 +73,74 @@ func createRepo(repoPath string) error {
 	}
 	cfg.Pubsub.Enabled = config.True
 
+	if err = applyProfiles(cfg, profiles); err != nil {
+		return err
+	}
+
 	// Create the repo with the config
 	err = fsrepo.Init(repoPath, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to init node: %s", err)
+		return fmt.Errorf("failed to init repo: %w", err)
 	}
 
 	return nil
 }
-func createNode(ctx context.Context, repoPath string) (*core.IpfsNode, icore.CoreAPI, error) {
+func createNode(ctx context.Context, repoPath string, opts *Options, box *secret.Box) (*core.IpfsNode, icore.CoreAPI, error) {
+
+	routing, err := routingOption(opts.Routing)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = checkProfiles(opts.Profiles); err != nil {
+		return nil, nil, err
+	}
 
 	repo, err := fsrepo.Open(repoPath)
 
 	if err != nil {
 
-		log.Printf("create node error: %s\n", err.Error())
-
 		//如果是属于没有repo的错误，则创建
 		if _, ok := err.(fsrepo.NoRepoError); ok {
-			err = createRepo(repoPath)
+			err = createRepo(repoPath, opts.Profiles)
+			if err != nil {
+				return nil, nil, fmt.Errorf("create repo: %w", err)
+			}
+			repo, err = fsrepo.Open(repoPath)
 			if err != nil {
-				log.Printf("create repo error: %s\n", err.Error())
-				return nil, nil, err
+				return nil, nil, fmt.Errorf("open repo: %w", err)
 			}
 		} else {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("open repo: %w", err)
 		}
 	}
 
+	//私有网络：有swarm key时，引导节点和传输协议都限制在私有网络。
+	//不修改libp2p全局的 pnet.ForcePrivateNetwork，同一进程中的其他实例可能在公共网络，
+	//每个节点在启动后检查 PNetFingerprint
+	private, err := setupSwarmKey(repoPath, opts)
+	if err != nil {
+		repo.Close()
+		return nil, nil, err
+	}
+
+	//有口令时节点私钥加密保存，解密后的私钥只放在内存配置中
+	privKey, err := openRepoIdentity(repo, box)
+	if err != nil {
+		repo.Close()
+		return nil, nil, err
+	}
+
+	nodeRepo, err := nodeConfig(repo, opts, private, privKey)
+	if err != nil {
+		repo.Close()
+		return nil, nil, err
+	}
+
 	nodeOptions := &core.BuildCfg{
-		Online:    true,
+		Online:    !opts.Offline,
 		Permanent: true,
-		Routing:   libp2p.DHTClientOption, // DHTOption
-		Repo:      repo,
+		Routing:   routing,
+		Repo:      nodeRepo,
 		ExtraOpts: map[string]bool{
 			"pubsub": true,
 		},
@@ -104,6 +151,12 @@ func createNode(ctx context.Context, repoPath string) (*core.IpfsNode, icore.Cor
 		return nil, nil, err
 	}
 
+	//不允许半私有的状态：有swarm key但节点没有使用
+	if private && !opts.Offline && node.PNetFingerprint == nil {
+		node.Close()
+		return nil, nil, fmt.Errorf("swarm key is not applied, refuse to join public network")
+	}
+
 	coreAPI, err := coreapi.NewCoreAPI(node)
 	if err != nil {
 		return nil, nil, err
@@ -112,6 +165,60 @@ func createNode(ctx context.Context, repoPath string) (*core.IpfsNode, icore.Cor
 	return node, coreAPI, nil
 }
 
+// 按启动参数中的地址和私有网络修改repo的配置，privKey 不为空时替换加密保存的节点私钥，
+// 返回使用修改后配置的repo。修改只在本次启动有效，不写入repo，没有需要修改的参数时返回原repo
+func nodeConfig(r repo.Repo, opts *Options, private bool, privKey string) (repo.Repo, error) {
+	if !private && privKey == "" && opts.Listen == nil && opts.Announce == nil && opts.Bootstrap == nil {
+		return r, nil
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err = cfg.Clone(); err != nil {
+		return nil, err
+	}
+
+	if privKey != "" {
+		cfg.Identity.PrivKey = privKey
+	}
+	if opts.Listen != nil {
+		cfg.Addresses.Swarm = opts.Listen
+	}
+	if opts.Announce != nil {
+		cfg.Addresses.Announce = opts.Announce
+	}
+
+	if private {
+		//私有网络还要去掉公共引导节点和不支持pnet的传输协议
+		err = privateConfig(cfg, opts.Bootstrap)
+	} else if opts.Bootstrap != nil {
+		cfg.Bootstrap = opts.Bootstrap
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &bootRepo{Repo: r, cfg: cfg}, nil
+}
+
+// 只在内存中修改配置的repo，本次启动使用，不写入磁盘
+type bootRepo struct {
+	repo.Repo
+	cfg *config.Config
+}
+
+func (r *bootRepo) Config() (*config.Config, error) {
+	return r.cfg, nil
+}
+
+// 运行中修改配置也只在内存中生效，以免把本次启动的修改写入repo
+func (r *bootRepo) SetConfig(cfg *config.Config) error {
+	r.cfg = cfg
+	return nil
+}
+
 func structToMap(v interface{}) (map[string]interface{}, error) {
 	vMap := &map[string]interface{}{}
 
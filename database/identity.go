@@ -0,0 +1,406 @@
+package database
+
+import (
+	"context"
+	"d-channel/secret"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/keystore"
+	orbitdb "berty.tech/go-orbit-db"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+const IDENTITIESDB = "self.identities"
+
+// 默认身份的名称，即orbitdb启动时创建的身份
+const DEFAULT_IDENTITY = "default"
+
+// NamedIdentity 命名的orbitdb身份，密钥保存在实例的keystore中
+type NamedIdentity struct {
+	Name      string `json:"name"`
+	ID        string `json:"id"`
+	PublicKey string `json:"publickey"`
+	CreatedAt string `json:"createdat,omitempty"`
+	Selected  bool   `json:"selected"`
+}
+
+// 只在本地保存的身份名称列表
+func (ins *Instance) getIdentitiesDB(ctx context.Context) (db orbitdb.KeyValueStore, err error) {
+	ins.identityLock.Lock()
+	defer ins.identityLock.Unlock()
+
+	localonly := true
+	if ins.IdentitiesDB == nil && ins.OrbitDB != nil {
+		ins.IdentitiesDB, err = ins.OrbitDB.KeyValue(ctx, IDENTITIESDB, ins.storeOptions(&orbitdb.CreateDBOptions{
+			LocalOnly: &localonly,
+		}))
+		if err != nil {
+			return
+		}
+		err = ins.IdentitiesDB.Load(ctx, -1)
+		if err != nil {
+			return
+		}
+	}
+	if ins.IdentitiesDB == nil {
+		return nil, fmt.Errorf("orbitdb is not ready")
+	}
+
+	return ins.IdentitiesDB, nil
+}
+
+// CreateIdentity 创建一个命名身份，名称在实例中唯一
+func (ins *Instance) CreateIdentity(ctx context.Context, name string) (*NamedIdentity, error) {
+	if name == "" || name == DEFAULT_IDENTITY {
+		return nil, fmt.Errorf("identity name can not be '%s'", name)
+	}
+
+	db, err := ins.getIdentitiesDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := db.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		return nil, fmt.Errorf("identity already exists: %s", name)
+	}
+
+	identity, err := ins.newIdentity(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	named := &NamedIdentity{
+		Name:      name,
+		ID:        identity.ID,
+		PublicKey: hex.EncodeToString(identity.PublicKey),
+		CreatedAt: time.Now().String(),
+	}
+
+	if value, err = json.Marshal(named); err != nil {
+		return nil, err
+	}
+	if _, err = db.Put(ctx, name, value); err != nil {
+		return nil, err
+	}
+
+	return named, nil
+}
+
+// 从keystore读取名称对应的密钥生成身份，密钥不存在时创建
+func (ins *Instance) newIdentity(ctx context.Context, name string) (*identityprovider.Identity, error) {
+	identity, err := identityprovider.CreateIdentity(ctx, &identityprovider.CreateIdentityOptions{
+		Keystore: ins.Keystore,
+		Type:     "orbitdb",
+		ID:       name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ins.identityLock.Lock()
+	ins.identities[name] = identity
+	ins.identityLock.Unlock()
+
+	return identity, nil
+}
+
+// ListIdentities 列出默认身份和所有命名身份
+func (ins *Instance) ListIdentities(ctx context.Context) ([]NamedIdentity, error) {
+	db, err := ins.getIdentitiesDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := ins.SelectedIdentity()
+
+	def := ins.OrbitDB.Identity()
+	list := []NamedIdentity{{
+		Name:      DEFAULT_IDENTITY,
+		ID:        def.ID,
+		PublicKey: hex.EncodeToString(def.PublicKey),
+		Selected:  selected == DEFAULT_IDENTITY,
+	}}
+
+	named := []NamedIdentity{}
+	for _, value := range db.All() {
+		identity := NamedIdentity{}
+		if err = json.Unmarshal(value, &identity); err != nil {
+			return nil, err
+		}
+		identity.Selected = selected == identity.Name
+		named = append(named, identity)
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].Name < named[j].Name })
+
+	return append(list, named...), nil
+}
+
+// Identity 按名称获取身份，名称为空时使用当前选择的身份
+func (ins *Instance) Identity(ctx context.Context, name string) (*identityprovider.Identity, error) {
+	if name == "" {
+		name = ins.SelectedIdentity()
+	}
+	if name == DEFAULT_IDENTITY {
+		return ins.OrbitDB.Identity(), nil
+	}
+
+	ins.identityLock.Lock()
+	identity, ok := ins.identities[name]
+	ins.identityLock.Unlock()
+	if ok {
+		return identity, nil
+	}
+
+	db, err := ins.getIdentitiesDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, err := db.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("identity not found: %s", name)
+	}
+
+	return ins.newIdentity(ctx, name)
+}
+
+// SelectIdentity 选择创建和打开数据库时默认使用的身份，只在本次运行中有效
+func (ins *Instance) SelectIdentity(ctx context.Context, name string) error {
+	if name == "" {
+		name = DEFAULT_IDENTITY
+	}
+	if _, err := ins.Identity(ctx, name); err != nil {
+		return err
+	}
+
+	ins.identityLock.Lock()
+	ins.selected = name
+	ins.identityLock.Unlock()
+
+	return nil
+}
+
+// SelectedIdentity 当前选择的身份名称
+func (ins *Instance) SelectedIdentity() string {
+	ins.identityLock.Lock()
+	defer ins.identityLock.Unlock()
+
+	if ins.selected == "" {
+		return DEFAULT_IDENTITY
+	}
+	return ins.selected
+}
+
+// 打开数据库使用的身份：指定的身份，或上次打开该数据库时的身份，或当前选择的身份
+func (ins *Instance) dbIdentity(ctx context.Context, address string, name string) (string, *identityprovider.Identity, error) {
+	if name == "" {
+		if info, err := ins.dbInfo(ctx, address); err == nil && info.Identity != "" {
+			name = info.Identity
+		} else {
+			name = ins.SelectedIdentity()
+		}
+	}
+
+	identity, err := ins.Identity(ctx, name)
+	return name, identity, err
+}
+
+// 身份导出文件的版本
+const identityBundleVersion = 1
+
+// 身份导出文件的内容，Keys 为keystore中的密钥，key为keystore中的ID，值为protobuf编码的私钥
+type identityBundle struct {
+	Version int               `json:"version"`
+	Name    string            `json:"name"`
+	ID      string            `json:"id"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// 身份在keystore中的两个密钥：按名称保存的密钥（身份ID是它的公钥），和按身份ID保存的签名密钥
+func (ins *Instance) identityKeyIDs(name string, identity *identityprovider.Identity) (nameKey string, idKey string) {
+	if name == DEFAULT_IDENTITY {
+		//默认身份的密钥按节点ID保存
+		return ins.IPFSNode.Identity.String(), identity.ID
+	}
+	return name, identity.ID
+}
+
+// ExportIdentity 导出身份和它的密钥，结果用口令加密（armor格式），可以在其他实例导入
+func (ins *Instance) ExportIdentity(ctx context.Context, name string, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase can not be empty")
+	}
+	if name == "" {
+		name = DEFAULT_IDENTITY
+	}
+
+	identity, err := ins.Identity(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := identityBundle{
+		Version: identityBundleVersion,
+		Name:    name,
+		ID:      identity.ID,
+		Keys:    map[string][]byte{},
+	}
+
+	nameKey, idKey := ins.identityKeyIDs(name, identity)
+	for role, keyID := range map[string]string{"name": nameKey, "id": idKey} {
+		priv, err := ins.Keystore.GetKey(ctx, keyID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get %s key: %w", role, err)
+		}
+		if bundle.Keys[role], err = crypto.MarshalPrivateKey(priv); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.EncryptWithPassphrase(data, passphrase)
+}
+
+// ImportIdentity 导入 ExportIdentity 导出的身份，name 为空时使用导出时的名称。
+// 导入后身份ID不变，原来授予该身份的写入权限在本实例同样有效
+func (ins *Instance) ImportIdentity(ctx context.Context, data []byte, passphrase string, name string) (*NamedIdentity, error) {
+	plain, err := secret.DecryptWithPassphrase(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := identityBundle{}
+	if err = json.Unmarshal(plain, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid identity bundle: %w", err)
+	}
+	if bundle.Version != identityBundleVersion {
+		return nil, fmt.Errorf("unsupported identity bundle version: %d", bundle.Version)
+	}
+
+	if name == "" {
+		name = bundle.Name
+	}
+	if name == "" || name == DEFAULT_IDENTITY {
+		return nil, fmt.Errorf("identity name can not be '%s', choose another name", name)
+	}
+
+	db, err := ins.getIdentitiesDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if value, err := db.Get(ctx, name); err != nil {
+		return nil, err
+	} else if value != nil {
+		return nil, fmt.Errorf("identity already exists: %s", name)
+	}
+	if has, err := ins.Keystore.HasKey(ctx, name); err != nil {
+		return nil, err
+	} else if has {
+		return nil, fmt.Errorf("key already exists in keystore: %s", name)
+	}
+
+	//检查密钥是否有效，再写入keystore
+	for role, key := range bundle.Keys {
+		if _, err = crypto.UnmarshalPrivateKey(key); err != nil {
+			return nil, fmt.Errorf("invalid %s key: %w", role, err)
+		}
+	}
+	if bundle.Keys["name"] == nil || bundle.Keys["id"] == nil {
+		return nil, fmt.Errorf("invalid identity bundle: missing keys")
+	}
+
+	//身份ID是按名称保存的密钥的公钥，写入前检查，不让不匹配的密钥进入keystore
+	if id, err := identityID(bundle.Keys["name"]); err != nil {
+		return nil, err
+	} else if id != bundle.ID {
+		return nil, fmt.Errorf("imported identity id mismatch: %s", id)
+	}
+
+	//按身份ID保存的密钥可能已经存在（同一个身份用其他名称导入过），回滚时不能删除
+	hasIDKey, err := ins.keystoreDS.Has(ctx, datastore.NewKey(bundle.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	//导入失败时删除本次写入的密钥。keystore会缓存读取过的密钥，换成新的keystore丢弃缓存，
+	//之后用同一个名称创建或导入身份时不会读到删除的密钥
+	rollback := func() {
+		_ = ins.keystoreDS.Delete(ctx, datastore.NewKey(name))
+		if !hasIDKey {
+			_ = ins.keystoreDS.Delete(ctx, datastore.NewKey(bundle.ID))
+		}
+
+		ins.identityLock.Lock()
+		delete(ins.identities, name)
+		if ks, err := keystore.NewKeystore(ins.keystoreDS); err == nil {
+			ins.Keystore = ks
+		}
+		ins.identityLock.Unlock()
+	}
+
+	if err = ins.keystoreDS.Put(ctx, datastore.NewKey(name), bundle.Keys["name"]); err != nil {
+		return nil, err
+	}
+	if err = ins.keystoreDS.Put(ctx, datastore.NewKey(bundle.ID), bundle.Keys["id"]); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	identity, err := ins.newIdentity(ctx, name)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	if identity.ID != bundle.ID {
+		rollback()
+		return nil, fmt.Errorf("imported identity id mismatch: %s", identity.ID)
+	}
+
+	named := &NamedIdentity{
+		Name:      name,
+		ID:        identity.ID,
+		PublicKey: hex.EncodeToString(identity.PublicKey),
+		CreatedAt: time.Now().String(),
+	}
+
+	value, err := json.Marshal(named)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	if _, err = db.Put(ctx, name, value); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	return named, nil
+}
+
+// orbitdb身份的ID：密钥公钥的十六进制
+func identityID(key []byte) (string, error) {
+	priv, err := crypto.UnmarshalPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := priv.GetPublic().Raw()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
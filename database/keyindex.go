@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+)
+
+// 已连接KV数据库的有序key索引，前缀和范围扫描只需要二分查找，不用每次排序全部key。
+// 索引按写入和同步事件异步更新，事件中的key以数据库当前的值为准；
+// 本节点的写入由 IndexKey 同步更新，写入后立即扫描也能看到
+type keyIndex struct {
+	lock sync.RWMutex
+	keys []string
+}
+
+func (x *keyIndex) reset(all map[string][]byte) {
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	x.lock.Lock()
+	x.keys = keys
+	x.lock.Unlock()
+}
+
+// present 为true时加入key，否则删除
+func (x *keyIndex) set(key string, present bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	i := sort.SearchStrings(x.keys, key)
+	found := i < len(x.keys) && x.keys[i] == key
+	switch {
+	case present && !found:
+		x.keys = append(x.keys, "")
+		copy(x.keys[i+1:], x.keys[i:])
+		x.keys[i] = key
+	case !present && found:
+		x.keys = append(x.keys[:i], x.keys[i+1:]...)
+	}
+}
+
+// KeyRange 有序key的范围：key 以 Prefix 开头并且在 [Start, End) 中。
+// After 不为空时从它之后开始（Reverse 时为之前），Limit 大于0时最多返回 Limit 个
+type KeyRange struct {
+	Prefix  string
+	Start   string
+	End     string
+	After   string
+	Reverse bool
+	Limit   int
+}
+
+func (x *keyIndex) scan(r KeyRange) (keys []string, more bool) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	search := func(f func(k string) bool) int {
+		return sort.Search(len(x.keys), func(i int) bool { return f(x.keys[i]) })
+	}
+
+	//有前缀的key是连续的一段
+	lo := search(func(k string) bool { return k >= r.Prefix })
+	hi := search(func(k string) bool { return k >= r.Prefix && !strings.HasPrefix(k, r.Prefix) })
+	if r.Start != "" {
+		lo = maxInt(lo, search(func(k string) bool { return k >= r.Start }))
+	}
+	if r.End != "" {
+		hi = minInt(hi, search(func(k string) bool { return k >= r.End }))
+	}
+	if r.After != "" {
+		if r.Reverse {
+			hi = minInt(hi, search(func(k string) bool { return k >= r.After }))
+		} else {
+			lo = maxInt(lo, search(func(k string) bool { return k > r.After }))
+		}
+	}
+	if lo >= hi {
+		return []string{}, false
+	}
+
+	n := hi - lo
+	if r.Limit > 0 && r.Limit < n {
+		n, more = r.Limit, true
+	}
+
+	keys = make([]string, n)
+	if r.Reverse {
+		for i := range keys {
+			keys[i] = x.keys[hi-1-i]
+		}
+	} else {
+		copy(keys, x.keys[lo:lo+n])
+	}
+	return keys, more
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// 建立KV数据库的索引并订阅事件更新，ctx 在数据库关闭或实例退出时取消。
+// 先订阅再读取全部key，读取期间的写入会在之后的事件中重新检查
+func (ins *Instance) indexKeys(ctx context.Context, db iface.KeyValueStore) {
+	sub, err := db.EventBus().Subscribe([]interface{}{
+		new(stores.EventWrite),
+		new(stores.EventReplicated),
+		new(stores.EventReady),
+	})
+	if err != nil {
+		return
+	}
+
+	address := db.Address().String()
+	index := &keyIndex{}
+	index.reset(db.All())
+
+	ins.indexLock.Lock()
+	if ins.keyIndexes == nil {
+		ins.keyIndexes = map[string]*keyIndex{}
+	}
+	ins.keyIndexes[address] = index
+	ins.indexLock.Unlock()
+
+	go func() {
+		defer sub.Close()
+		defer func() {
+			ins.indexLock.Lock()
+			if ins.keyIndexes[address] == index {
+				delete(ins.keyIndexes, address)
+			}
+			ins.indexLock.Unlock()
+		}()
+
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				if _, ok := e.(stores.EventReady); ok {
+					index.reset(db.All())
+					continue
+				}
+				_, entries := webhookEntries(e)
+				for _, entry := range entries {
+					for _, change := range entryChanges(entry) {
+						updateKey(ctx, index, db, change.key)
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func updateKey(ctx context.Context, index *keyIndex, db iface.KeyValueStore, key string) {
+	value, err := db.Get(ctx, key)
+	index.set(key, err == nil && value != nil)
+}
+
+// IndexKey 本节点写入或删除key后调用，按数据库当前的值更新索引
+func (ins *Instance) IndexKey(ctx context.Context, db iface.KeyValueStore, key string) {
+	ins.indexLock.RLock()
+	index, ok := ins.keyIndexes[db.Address().String()]
+	ins.indexLock.RUnlock()
+	if ok {
+		updateKey(ctx, index, db, key)
+	}
+}
+
+// ScanKeys 按顺序返回已连接KV数据库中范围内的key，more 表示范围内还有更多的key。
+// 数据库没有索引（不是已连接的KV数据库）时 ok 为false
+func (ins *Instance) ScanKeys(address string, r KeyRange) (keys []string, more bool, ok bool) {
+	ins.indexLock.RLock()
+	index, ok := ins.keyIndexes[address]
+	ins.indexLock.RUnlock()
+	if !ok {
+		return nil, false, false
+	}
+
+	keys, more = index.scan(r)
+	return keys, more, true
+}
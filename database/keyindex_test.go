@@ -0,0 +1,35 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyIndexScan(t *testing.T) {
+	index := &keyIndex{}
+	index.reset(map[string][]byte{"a": nil, "b/1": nil, "b/2": nil, "b/3": nil, "c": nil})
+	index.set("b/0", true)
+	index.set("c", false)
+	index.set("c", false)
+
+	cases := []struct {
+		r    KeyRange
+		keys []string
+		more bool
+	}{
+		{KeyRange{}, []string{"a", "b/0", "b/1", "b/2", "b/3"}, false},
+		{KeyRange{Prefix: "b/", Limit: 2}, []string{"b/0", "b/1"}, true},
+		{KeyRange{Prefix: "b/", After: "b/1", Limit: 2}, []string{"b/2", "b/3"}, false},
+		{KeyRange{Prefix: "b/", Reverse: true, Limit: 3}, []string{"b/3", "b/2", "b/1"}, true},
+		{KeyRange{Prefix: "b/", Reverse: true, After: "b/1"}, []string{"b/0"}, false},
+		{KeyRange{Start: "b/1", End: "b/3"}, []string{"b/1", "b/2"}, false},
+		{KeyRange{Prefix: "x"}, []string{}, false},
+	}
+
+	for _, c := range cases {
+		keys, more := index.scan(c.r)
+		if !reflect.DeepEqual(keys, c.keys) || more != c.more {
+			t.Errorf("%+v: got %v %v, want %v %v", c.r, keys, more, c.keys, c.more)
+		}
+	}
+}
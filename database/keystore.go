@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"d-channel/secret"
+	"encoding/base64"
+	"path/filepath"
+
+	"berty.tech/go-ipfs-log/keystore"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipfs/kubo/repo"
+)
+
+// 与orbitdb默认的keystore使用相同的目录，设置口令前后orbitdb身份不变
+func keystorePath(dir, id string) string {
+	return filepath.Join(dir, id, "keystore")
+}
+
+// 打开orbitdb keystore。box不为空时keystore用口令加密，之前保存的明文密钥会被重新加密
+func openKeystore(ctx context.Context, path string, box *secret.Box) (*keystore.Keystore, datastore.Datastore, error) {
+	ds, err := leveldb.NewDatastore(path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if box == nil {
+		ks, err := keystore.NewKeystore(ds)
+		if err != nil {
+			ds.Close()
+			return nil, nil, err
+		}
+		return ks, ds, nil
+	}
+
+	sealed := &sealedDatastore{Datastore: ds, box: box}
+	if err = sealed.migrate(ctx); err != nil {
+		ds.Close()
+		return nil, nil, err
+	}
+
+	ks, err := keystore.NewKeystore(sealed)
+	if err != nil {
+		ds.Close()
+		return nil, nil, err
+	}
+
+	return ks, sealed, nil
+}
+
+// sealedDatastore 保存前加密、读取后解密值的datastore，key不加密
+type sealedDatastore struct {
+	datastore.Datastore
+	box *secret.Box
+}
+
+func (d *sealedDatastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	sealed, err := d.box.Seal(value)
+	if err != nil {
+		return err
+	}
+	return d.Datastore.Put(ctx, key, sealed)
+}
+
+func (d *sealedDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	value, err := d.Datastore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return d.box.Open(value)
+}
+
+func (d *sealedDatastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	value, err := d.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	return len(value), nil
+}
+
+// 只支持按key查询，值的过滤和排序在解密后进行
+func (d *sealedDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	results, err := d.Datastore.Query(ctx, query.Query{Prefix: q.Prefix, KeysOnly: q.KeysOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	opened := query.ResultsFromIterator(query.Query{Prefix: q.Prefix, KeysOnly: q.KeysOnly}, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := results.NextSync()
+			if !ok || r.Error != nil || q.KeysOnly {
+				return r, ok
+			}
+			r.Value, r.Error = d.box.Open(r.Value)
+			r.Size = len(r.Value)
+			return r, true
+		},
+		Close: results.Close,
+	})
+
+	return query.NaiveQueryApply(query.Query{
+		Filters: q.Filters,
+		Orders:  q.Orders,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+	}, opened), nil
+}
+
+// 加密设置口令之前保存的明文值
+func (d *sealedDatastore) migrate(ctx context.Context) error {
+	results, err := d.Datastore.Query(ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if secret.IsSealed(e.Value) {
+			continue
+		}
+		if err = d.Put(ctx, datastore.NewKey(e.Key), e.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 节点的libp2p私钥（repo配置中的 Identity.PrivKey）同样用数据密钥加密保存。
+// 有口令时把明文私钥加密后写回repo，返回解密后的私钥，只在本次启动的内存配置中使用；
+// 私钥已加密但没有口令时返回 secret.ErrLocked。返回空字符串表示私钥没有加密
+func openRepoIdentity(r repo.Repo, box *secret.Box) (string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	if isSealedString(cfg.Identity.PrivKey) {
+		return openString(box, cfg.Identity.PrivKey)
+	}
+
+	if box == nil {
+		return "", nil
+	}
+
+	plain := cfg.Identity.PrivKey
+	sealed, err := sealString(box, plain)
+	if err != nil {
+		return "", err
+	}
+	if err = r.SetConfigKey("Identity.PrivKey", sealed); err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// 配置等文本中保存的加密值为 Box.Seal 结果的base64。
+// kubo的私钥和回调的secret解码后都不会有 Box.Seal 的前缀
+func sealString(box *secret.Box, s string) (string, error) {
+	sealed, err := box.Seal([]byte(s))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func isSealedString(s string) bool {
+	data, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && secret.IsSealed(data)
+}
+
+// 解密 sealString 的结果，没有口令时返回 secret.ErrLocked
+func openString(box *secret.Box, s string) (string, error) {
+	if box == nil {
+		return "", secret.ErrLocked
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	plain, err := box.Open(data)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Lock 关闭实例和IPFS节点，并清除内存中的密钥，之后需要用口令重新启动。
+// orbitdb keystore、本地age密钥和节点私钥在磁盘上都是加密的
+func (ins *Instance) Lock() (err error) {
+	if err = ins.Close(); err != nil {
+		return
+	}
+
+	if ins.IPFSNode != nil {
+		if err = ins.IPFSNode.Close(); err != nil {
+			return
+		}
+	}
+
+	ins.secretLock.Lock()
+	defer ins.secretLock.Unlock()
+
+	ins.keys = nil
+	if ins.box != nil {
+		ins.box.Close()
+		ins.box = nil
+	}
+
+	return
+}
+
+// ChangePassphrase 修改加密密钥的口令，只需要重新加密数据密钥
+func (ins *Instance) ChangePassphrase(oldpassphrase, newpassphrase string) error {
+	return secret.ChangePassphrase(ins.Dir, oldpassphrase, newpassphrase)
+}
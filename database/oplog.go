@@ -0,0 +1,365 @@
+package database
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/operation"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrConflict 条件写入时，key的当前值不是来自客户端看到的entry
+var ErrConflict = errors.New("conflict: value has been changed by another entry")
+
+// 条件写入在检查和写入之间加数据库的锁，避免本节点的并发请求互相覆盖
+func (ins *Instance) casLock(address string) *sync.Mutex {
+	ins.casLocksLock.Lock()
+	defer ins.casLocksLock.Unlock()
+
+	if ins.casLocks == nil {
+		ins.casLocks = map[string]*sync.Mutex{}
+	}
+	lock, ok := ins.casLocks[address]
+	if !ok {
+		lock = &sync.Mutex{}
+		ins.casLocks[address] = lock
+	}
+	return lock
+}
+
+// Condition 条件写入的条件。
+// Entry 为客户端最后看到的修改该key的entry CID，为空表示客户端认为key当前没有值；
+// Heads 为客户端最后看到的oplog heads，key的当前值必须来自这些heads的历史。
+// 两者都提供时都要满足
+type Condition struct {
+	Entry string   `json:"entry"`
+	Heads []string `json:"heads"`
+}
+
+// LatestEntry 返回oplog中最后一次修改key（PUT或DEL）的entry
+func LatestEntry(db iface.Store, key string) (ipfslog.Entry, operation.Operation, bool) {
+	entries := db.OpLog().Values().Slice()
+	for i := len(entries) - 1; i >= 0; i-- {
+		op, err := operation.ParseOperation(entries[i])
+		if err != nil || op.GetKey() == nil || *op.GetKey() != key {
+			continue
+		}
+		return entries[i], op, true
+	}
+	return nil, nil, false
+}
+
+// CompareAndPut 满足条件时写入key，否则返回 ErrConflict
+func (ins *Instance) CompareAndPut(ctx context.Context, db iface.KeyValueStore, key string, value []byte, cond *Condition) (operation.Operation, error) {
+	lock := ins.casLock(db.Address().String())
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := checkCondition(db, key, cond); err != nil {
+		return nil, err
+	}
+
+	return db.Put(ctx, key, value)
+}
+
+// CompareAndDelete 满足条件时删除key，否则返回 ErrConflict
+func (ins *Instance) CompareAndDelete(ctx context.Context, db iface.KeyValueStore, key string, cond *Condition) (operation.Operation, error) {
+	lock := ins.casLock(db.Address().String())
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := checkCondition(db, key, cond); err != nil {
+		return nil, err
+	}
+
+	return db.Delete(ctx, key)
+}
+
+func checkCondition(db iface.Store, key string, cond *Condition) error {
+	if cond == nil {
+		cond = &Condition{}
+	}
+
+	latest, op, found := LatestEntry(db, key)
+
+	//只提供heads时不检查entry
+	if cond.Entry != "" || len(cond.Heads) == 0 {
+		current := ""
+		if found {
+			current = latest.GetHash().String()
+		}
+		deleted := !found || op.GetOperation() == "DEL"
+
+		if cond.Entry == "" && !deleted {
+			return ErrConflict
+		}
+		if cond.Entry != "" && cond.Entry != current {
+			return ErrConflict
+		}
+	}
+
+	if len(cond.Heads) > 0 && found {
+		reachable, err := reachableFrom(db.OpLog(), cond.Heads, latest.GetHash())
+		if err != nil {
+			return err
+		}
+		if !reachable {
+			return ErrConflict
+		}
+	}
+
+	return nil
+}
+
+// 从heads沿next指针向前查找，判断target是否在heads的历史中
+func reachableFrom(oplog ipfslog.Log, heads []string, target cid.Cid) (bool, error) {
+	set, err := ancestors(oplog, heads)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := set[target.String()]
+	return ok, nil
+}
+
+// 返回heads以及沿next指针能到达的所有entry的CID集合
+func ancestors(oplog ipfslog.Log, heads []string) (map[string]struct{}, error) {
+	queue := make([]cid.Cid, 0, len(heads))
+	for _, h := range heads {
+		c, err := cid.Decode(h)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c)
+	}
+
+	visited := map[string]struct{}{}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		if _, ok := visited[c.String()]; ok {
+			continue
+		}
+		visited[c.String()] = struct{}{}
+
+		e, ok := oplog.Get(c)
+		if !ok {
+			continue
+		}
+		queue = append(queue, e.GetNext()...)
+	}
+
+	return visited, nil
+}
+
+// Clock entry的Lamport时钟
+type Clock struct {
+	ID   string `json:"id"`
+	Time int    `json:"time"`
+}
+
+// Version key或文档的一个历史版本
+type Version struct {
+	CID      string `json:"cid"`
+	Clock    Clock  `json:"clock"`
+	Identity string `json:"identity"`
+	Op       string `json:"op"`
+	Value    []byte `json:"value,omitempty"`
+}
+
+// 一条oplog记录修改的一个key，PUTALL 会修改多个key
+type keyChange struct {
+	key   string
+	op    string
+	value []byte
+}
+
+// 解析entry修改了哪些key：PUT/DEL修改一个key，docstore的PUTALL修改多个文档
+func entryChanges(e ipfslog.Entry) []keyChange {
+	op, err := operation.ParseOperation(e)
+	if err != nil {
+		return nil
+	}
+
+	switch op.GetOperation() {
+	case "PUT", "DEL":
+		if op.GetKey() == nil {
+			return nil
+		}
+		return []keyChange{{key: *op.GetKey(), op: op.GetOperation(), value: op.GetValue()}}
+	case "PUTALL":
+		changes := make([]keyChange, 0, len(op.GetDocs()))
+		for _, doc := range op.GetDocs() {
+			changes = append(changes, keyChange{key: doc.Key, op: "PUT", value: doc.Value})
+		}
+		return changes
+	}
+
+	return nil
+}
+
+func clockOf(e ipfslog.Entry) Clock {
+	return Clock{ID: hex.EncodeToString(e.GetClock().GetID()), Time: e.GetClock().GetTime()}
+}
+
+func identityOf(e ipfslog.Entry) string {
+	if e.GetIdentity() == nil {
+		return ""
+	}
+	return e.GetIdentity().ID
+}
+
+// KeyHistory 按oplog顺序（从旧到新）返回KV的key或docstore文档ID的所有版本
+func KeyHistory(db iface.Store, key string) ([]Version, error) {
+	switch db.Type() {
+	case STORETYPE_KV, STORETYPE_DOCS:
+	default:
+		return nil, fmt.Errorf("history is not supported by %s", db.Type())
+	}
+
+	versions := []Version{}
+	for _, e := range db.OpLog().Values().Slice() {
+		for _, change := range entryChanges(e) {
+			if change.key != key {
+				continue
+			}
+			versions = append(versions, Version{
+				CID:      e.GetHash().String(),
+				Clock:    clockOf(e),
+				Identity: identityOf(e),
+				Op:       change.op,
+				Value:    change.value,
+			})
+		}
+	}
+
+	return versions, nil
+}
+
+// IdentityInfo 写入entry的orbitdb身份，公钥和签名用hex表示
+type IdentityInfo struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	PublicKey    string `json:"publickey"`
+	IDSignature  string `json:"idsignature"`
+	KeySignature string `json:"keysignature"`
+}
+
+// EntryInfo oplog中一个entry的完整信息，Payload 为operation的原始json
+type EntryInfo struct {
+	CID      string          `json:"cid"`
+	LogID    string          `json:"logid"`
+	Payload  json.RawMessage `json:"payload"`
+	Next     []string        `json:"next"`
+	Refs     []string        `json:"refs"`
+	Clock    Clock           `json:"clock"`
+	Identity *IdentityInfo   `json:"identity"`
+	Key      string          `json:"key"`
+	Sig      string          `json:"sig"`
+	V        uint64          `json:"v"`
+}
+
+// NewEntryInfo 转换entry为可以序列化的结构
+func NewEntryInfo(e ipfslog.Entry) *EntryInfo {
+	info := &EntryInfo{
+		CID:   e.GetHash().String(),
+		LogID: e.GetLogID(),
+		Next:  cidStrings(e.GetNext()),
+		Refs:  cidStrings(e.GetRefs()),
+		Clock: clockOf(e),
+		Key:   hex.EncodeToString(e.GetKey()),
+		Sig:   hex.EncodeToString(e.GetSig()),
+		V:     e.GetV(),
+	}
+
+	//payload不是json时按字符串返回
+	if json.Valid(e.GetPayload()) {
+		info.Payload = e.GetPayload()
+	} else {
+		info.Payload, _ = json.Marshal(string(e.GetPayload()))
+	}
+
+	if id := e.GetIdentity(); id != nil {
+		info.Identity = &IdentityInfo{
+			ID:        id.ID,
+			Type:      id.Type,
+			PublicKey: hex.EncodeToString(id.PublicKey),
+		}
+		if id.Signatures != nil {
+			info.Identity.IDSignature = hex.EncodeToString(id.Signatures.ID)
+			info.Identity.KeySignature = hex.EncodeToString(id.Signatures.PublicKey)
+		}
+	}
+
+	return info
+}
+
+func cidStrings(cids []cid.Cid) []string {
+	out := make([]string, 0, len(cids))
+	for _, c := range cids {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+// LogHeads 数据库oplog当前的heads
+func LogHeads(db iface.Store) []*EntryInfo {
+	heads := db.OpLog().Heads().Slice()
+
+	infos := make([]*EntryInfo, 0, len(heads))
+	for _, e := range heads {
+		infos = append(infos, NewEntryInfo(e))
+	}
+	return infos
+}
+
+// LogEntry 按CID获取oplog中的一个entry
+func LogEntry(db iface.Store, c string) (*EntryInfo, error) {
+	_cid, err := cid.Decode(c)
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := db.OpLog().Get(_cid)
+	if !ok {
+		return nil, fmt.Errorf("entry not found: %s", c)
+	}
+
+	return NewEntryInfo(e), nil
+}
+
+// WalkLog 从新到旧遍历oplog，从after之后开始最多返回limit个entry，limit不大于0时返回全部。
+// more 表示后面还有entry
+func WalkLog(db iface.Store, after string, limit int) (infos []*EntryInfo, more bool, err error) {
+	entries := db.OpLog().Values().Slice()
+
+	i := len(entries) - 1
+	if after != "" {
+		for ; i >= 0; i-- {
+			if entries[i].GetHash().String() == after {
+				break
+			}
+		}
+		if i < 0 {
+			return nil, false, fmt.Errorf("entry not found: %s", after)
+		}
+		i--
+	}
+
+	infos = []*EntryInfo{}
+	for ; i >= 0; i-- {
+		if limit > 0 && len(infos) == limit {
+			return infos, true, nil
+		}
+		infos = append(infos, NewEntryInfo(entries[i]))
+	}
+
+	return infos, false, nil
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+)
+
+// 同步状态
+const (
+	SYNC_WAITING     = "waiting"     //还没有从其他节点同步过
+	SYNC_REPLICATING = "replicating" //正在同步
+	SYNC_STALLED     = "stalled"     //同步队列不为空，但一段时间没有进展
+	SYNC_SYNCED      = "synced"      //同步队列为空
+)
+
+// 同步中超过这个时间没有进展，认为同步停滞
+const stallTimeout = time.Minute
+
+// SyncStatus 数据库的同步状态。
+// Loaded 为本地oplog的entry数量，Progress/Known 为同步进度中已加载和已知的entry数量，
+// Queued 为等待同步的heads，Peers 为交换过heads的节点
+type SyncStatus struct {
+	State          string   `json:"state"`
+	Loaded         int      `json:"loaded"`
+	Progress       int      `json:"progress"`
+	Known          int      `json:"known"`
+	Queued         []string `json:"queued"`
+	LastReplicated string   `json:"lastreplicated,omitempty"`
+	LastProgress   string   `json:"lastprogress,omitempty"`
+	Peers          []string `json:"peers"`
+}
+
+// 记录一个数据库的同步事件
+type syncTracker struct {
+	lock           sync.RWMutex
+	lastReplicated time.Time
+	lastProgress   time.Time
+	peers          []string
+}
+
+func (t *syncTracker) addPeer(p string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, old := range t.peers {
+		if old == p {
+			return
+		}
+	}
+	t.peers = append(t.peers, p)
+}
+
+// 订阅同步事件，更新数据库的同步记录，ctx 在数据库关闭或实例退出时取消
+func (ins *Instance) trackReplication(ctx context.Context, db iface.Store) {
+	tracker := &syncTracker{}
+	address := db.Address().String()
+
+	ins.syncLock.Lock()
+	ins.syncTrackers[address] = tracker
+	ins.syncLock.Unlock()
+
+	defer func() {
+		ins.syncLock.Lock()
+		if ins.syncTrackers[address] == tracker {
+			delete(ins.syncTrackers, address)
+		}
+		ins.syncLock.Unlock()
+	}()
+
+	sub, err := db.EventBus().Subscribe([]interface{}{
+		new(stores.EventReplicate),
+		new(stores.EventReplicateProgress),
+		new(stores.EventReplicated),
+		new(stores.EventNewPeer),
+	})
+	if err != nil {
+		return
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+
+			now := time.Now()
+			switch e := e.(type) {
+			case stores.EventReplicate, stores.EventReplicateProgress:
+				tracker.lock.Lock()
+				tracker.lastProgress = now
+				tracker.lock.Unlock()
+			case stores.EventReplicated:
+				ins.markSealed(address, e.Entries)
+				tracker.lock.Lock()
+				tracker.lastProgress = now
+				tracker.lastReplicated = now
+				tracker.lock.Unlock()
+			case stores.EventNewPeer:
+				tracker.addPeer(e.Peer.String())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ins *Instance) untrackReplication(address string) {
+	ins.syncLock.Lock()
+	delete(ins.syncTrackers, address)
+	ins.syncLock.Unlock()
+}
+
+// SyncStatus 查看已连接数据库的同步状态
+func (ins *Instance) SyncStatus(address string) (*SyncStatus, error) {
+	db, ok := ins.ConnectingDB[address]
+	if !ok {
+		return nil, fmt.Errorf("db is not connecting: %s", address)
+	}
+
+	ins.syncLock.RLock()
+	tracker, ok := ins.syncTrackers[address]
+	ins.syncLock.RUnlock()
+	if !ok {
+		tracker = &syncTracker{}
+	}
+
+	status := &SyncStatus{
+		Loaded: db.OpLog().Len(),
+		Queued: []string{},
+	}
+
+	if info := db.ReplicationStatus(); info != nil {
+		status.Progress = info.GetProgress()
+		status.Known = info.GetMax()
+	}
+	if r := db.Replicator(); r != nil {
+		for _, c := range r.GetQueue() {
+			status.Queued = append(status.Queued, c.String())
+		}
+	}
+
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	status.Peers = append([]string{}, tracker.peers...)
+	if !tracker.lastReplicated.IsZero() {
+		status.LastReplicated = tracker.lastReplicated.Format(time.RFC3339)
+	}
+	if !tracker.lastProgress.IsZero() {
+		status.LastProgress = tracker.lastProgress.Format(time.RFC3339)
+	}
+
+	switch {
+	case len(status.Queued) > 0 || status.Progress < status.Known:
+		status.State = SYNC_REPLICATING
+		if time.Since(tracker.lastProgress) > stallTimeout {
+			status.State = SYNC_STALLED
+		}
+	case tracker.lastReplicated.IsZero() && len(tracker.peers) == 0:
+		status.State = SYNC_WAITING
+	default:
+		status.State = SYNC_SYNCED
+	}
+
+	return status, nil
+}
+
+// DBInfos 所有保存过的数据库信息，已连接的数据库附带同步状态
+func (ins *Instance) DBInfos(ctx context.Context) (infos map[string]DBInfo, err error) {
+	var programs map[string][]byte
+	programs, err = ins.GetProgramsDB(ctx)
+	if err != nil {
+		return
+	}
+
+	infos = make(map[string]DBInfo, len(programs))
+	for address, value := range programs {
+		info := DBInfo{}
+		if err = json.Unmarshal(value, &info); err != nil {
+			return
+		}
+		if _, ok := ins.ConnectingDB[address]; ok {
+			info.Sync, _ = ins.SyncStatus(address)
+		}
+		infos[address] = info
+	}
+
+	return
+}
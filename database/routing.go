@@ -0,0 +1,65 @@
+package database
+
+import (
+	"fmt"
+
+	orbitdb "berty.tech/go-orbit-db"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/libp2p"
+)
+
+// 节点的路由模式
+const (
+	ROUTING_CLIENT = "client" //只查询DHT，不为其他节点提供服务（默认）
+	ROUTING_SERVER = "server" //作为DHT服务节点，需要公网可达
+	ROUTING_AUTO   = "auto"   //按是否公网可达自动切换
+	ROUTING_NONE   = "none"   //不使用DHT，只能连接引导节点和已知节点
+)
+
+// 把路由模式转换为kubo的路由选项，空为 ROUTING_CLIENT
+func routingOption(mode string) (libp2p.RoutingOption, error) {
+	switch mode {
+	case "", ROUTING_CLIENT:
+		return libp2p.DHTClientOption, nil
+	case ROUTING_SERVER:
+		return libp2p.DHTServerOption, nil
+	case ROUTING_AUTO:
+		return libp2p.DHTOption, nil
+	case ROUTING_NONE:
+		return libp2p.NilRouterOption, nil
+	}
+	return nil, fmt.Errorf("unknown routing mode: %s", mode)
+}
+
+// 检查kubo配置profile的名称，如 server、lowpower、test、local-discovery
+func checkProfiles(profiles []string) error {
+	for _, name := range profiles {
+		if _, ok := config.Profiles[name]; !ok {
+			return fmt.Errorf("unknown profile: %s", name)
+		}
+	}
+	return nil
+}
+
+// 按顺序把profile应用到新建repo的配置
+func applyProfiles(cfg *config.Config, profiles []string) error {
+	for _, name := range profiles {
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile: %s", name)
+		}
+		if err := profile.Transform(cfg); err != nil {
+			return fmt.Errorf("apply profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// 离线时不订阅pubsub，数据库只在本地读写
+func (ins *Instance) storeOptions(opts *orbitdb.CreateDBOptions) *orbitdb.CreateDBOptions {
+	if ins.Offline {
+		replicate := false
+		opts.Replicate = &replicate
+	}
+	return opts
+}
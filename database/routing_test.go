@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"berty.tech/go-orbit-db/iface"
+	"github.com/ipfs/kubo/core/node/libp2p"
+)
+
+func TestRoutingOption(t *testing.T) {
+	cases := []struct {
+		mode string
+		want libp2p.RoutingOption
+	}{
+		{"", libp2p.DHTClientOption},
+		{ROUTING_CLIENT, libp2p.DHTClientOption},
+		{ROUTING_SERVER, libp2p.DHTServerOption},
+		{ROUTING_AUTO, libp2p.DHTOption},
+		{ROUTING_NONE, libp2p.NilRouterOption},
+		{"dhtclient", nil},
+	}
+
+	for _, c := range cases {
+		got, err := routingOption(c.mode)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected error", c.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.mode, err)
+			continue
+		}
+		//函数不能直接比较，比较函数地址
+		if reflect.ValueOf(got).Pointer() != reflect.ValueOf(c.want).Pointer() {
+			t.Errorf("%q: got wrong routing option", c.mode)
+		}
+	}
+}
+
+func TestCheckProfiles(t *testing.T) {
+	cases := []struct {
+		profiles []string
+		ok       bool
+	}{
+		{nil, true},
+		{[]string{"test"}, true},
+		{[]string{"lowpower", "local-discovery"}, true},
+		{[]string{"test", "nosuch"}, false},
+		{[]string{""}, false},
+	}
+
+	for _, c := range cases {
+		if err := checkProfiles(c.profiles); (err == nil) != c.ok {
+			t.Errorf("%v: got %v, want ok=%v", c.profiles, err, c.ok)
+		}
+	}
+}
+
+// 离线启动时不连接网络，数据库仍然可以在本地读写
+func TestBootOffline(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	ins, err := BootInstance(ctx, &Options{
+		RepoPath: filepath.Join(dir, "repo"),
+		DBPath:   filepath.Join(dir, "db"),
+		Profiles: []string{"test"},
+		Offline:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ins.Lock()
+
+	if !ins.Offline || ins.IPFSNode.IsOnline {
+		t.Fatalf("expected an offline node, online=%v", ins.IPFSNode.IsOnline)
+	}
+
+	db, err := ins.CreateDB(ctx, "offline", STORETYPE_KV, ACL{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := db.(iface.KeyValueStore)
+	if _, err = kv.Put(ctx, "k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := kv.Get(ctx, "k")
+	if err != nil || string(value) != "v" {
+		t.Errorf("get: %q %v", value, err)
+	}
+
+	if err = ins.CloseDB(ctx, db.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// 私有网络的预共享密钥文件，保存在ipfs repo中
+const SWARMKEY_FILE = "swarm.key"
+
+// GenerateSwarmKey 生成新的私有网络密钥，格式与 ipfs-swarm-key-gen 相同
+func GenerateSwarmKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key) + "\n", nil
+}
+
+// ParseSwarmKey 检查swarm key的格式
+func ParseSwarmKey(key string) error {
+	if _, err := pnet.DecodeV1PSK(bytes.NewReader([]byte(key))); err != nil {
+		return fmt.Errorf("invalid swarm key: %w", err)
+	}
+	return nil
+}
+
+// 读取repo中的swarm key，没有时返回空
+func readSwarmKey(repoPath string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, SWARMKEY_FILE))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// 按启动参数准备repo中的swarm key，返回是否为私有网络。
+// repo中已有不同的密钥时拒绝启动，避免节点加入错误的网络
+func setupSwarmKey(repoPath string, opts *Options) (private bool, err error) {
+	existing, err := readSwarmKey(repoPath)
+	if err != nil {
+		return
+	}
+
+	key := opts.SwarmKey
+	if key == "" && opts.GenerateSwarmKey && existing == "" {
+		if key, err = GenerateSwarmKey(); err != nil {
+			return
+		}
+	}
+
+	if key == "" {
+		if existing == "" && opts.Private {
+			return false, fmt.Errorf("private network requires a swarm key")
+		}
+		return existing != "", nil
+	}
+
+	if err = ParseSwarmKey(key); err != nil {
+		return
+	}
+	if existing != "" {
+		if sameSwarmKey(existing, key) {
+			return true, nil
+		}
+		return false, fmt.Errorf("repo already has a different swarm key")
+	}
+
+	return true, os.WriteFile(filepath.Join(repoPath, SWARMKEY_FILE), []byte(key), 0600)
+}
+
+func sameSwarmKey(a, b string) bool {
+	pa, err := pnet.DecodeV1PSK(bytes.NewReader([]byte(a)))
+	if err != nil {
+		return false
+	}
+	pb, err := pnet.DecodeV1PSK(bytes.NewReader([]byte(b)))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(pa, pb)
+}
+
+// 私有网络的配置：引导节点只能是私有网络中的节点，去掉不支持预共享密钥的QUIC/WebTransport地址。
+// bootstrap 为nil时保留repo中已配置的非公共引导节点
+func privateConfig(cfg *config.Config, bootstrap []string) error {
+	if bootstrap == nil {
+		for _, addr := range cfg.Bootstrap {
+			if !isPublicBootstrap(addr) {
+				bootstrap = append(bootstrap, addr)
+			}
+		}
+	}
+	for _, addr := range bootstrap {
+		if isPublicBootstrap(addr) {
+			return fmt.Errorf("public bootstrap peer is not allowed in private network: %s", addr)
+		}
+	}
+	cfg.Bootstrap = bootstrap
+
+	swarm := []string{}
+	for _, addr := range cfg.Addresses.Swarm {
+		if strings.Contains(addr, "/quic") || strings.Contains(addr, "/webtransport") {
+			continue
+		}
+		swarm = append(swarm, addr)
+	}
+	cfg.Addresses.Swarm = swarm
+
+	cfg.Swarm.Transports.Network.QUIC = config.False
+	cfg.Swarm.Transports.Network.WebTransport = config.False
+
+	return nil
+}
+
+func isPublicBootstrap(addr string) bool {
+	for _, public := range config.DefaultBootstrapAddresses {
+		if addr == public {
+			return true
+		}
+	}
+	return false
+}
+
+// SwarmKey 实例所在私有网络的密钥，用于加入其他节点。公共网络返回空
+func (ins *Instance) SwarmKey() (string, error) {
+	return readSwarmKey(ins.Repo)
+}
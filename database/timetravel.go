@@ -0,0 +1,158 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/operation"
+)
+
+// AsOf 历史时间点，Heads 为当时的oplog heads，Clock 为Lamport时钟，
+// 只包含heads历史中的entry，或时钟不大于Clock的entry。两者都提供时都要满足
+type AsOf struct {
+	Heads []string `json:"heads"`
+	Clock int      `json:"clock"`
+}
+
+// Snapshot 按时间点从oplog重建的只读数据。
+// Heads 和 Entries 为重建时使用的entry的heads和数量，Data 的结构与对应数据库类型的 all/list/value 相同
+type Snapshot struct {
+	Heads   []string    `json:"heads"`
+	Entries int         `json:"entries"`
+	Data    interface{} `json:"data"`
+}
+
+// SnapshotAt 用oplog中截止到指定时间点的entry重建数据，不修改正在使用的数据库。
+// key不为空时只返回该key（KV）或该文档（docstore）
+func SnapshotAt(db iface.Store, at *AsOf, key string) (snap *Snapshot, err error) {
+	if at == nil || (len(at.Heads) == 0 && at.Clock <= 0) {
+		return nil, fmt.Errorf("heads or clock is required")
+	}
+
+	oplog := db.OpLog()
+
+	var included map[string]struct{}
+	if len(at.Heads) > 0 {
+		included, err = ancestors(oplog, at.Heads)
+		if err != nil {
+			return
+		}
+	}
+
+	entries := []ipfslog.Entry{}
+	for _, e := range oplog.Values().Slice() {
+		if included != nil {
+			if _, ok := included[e.GetHash().String()]; !ok {
+				continue
+			}
+		}
+		if at.Clock > 0 && e.GetClock().GetTime() > at.Clock {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	snap = &Snapshot{Heads: headsOf(entries), Entries: len(entries)}
+
+	switch db.Type() {
+	case STORETYPE_KV:
+		snap.Data, err = rebuildKV(entries, key)
+	case STORETYPE_DOCS:
+		snap.Data, err = rebuildDocs(entries, key)
+	case STORETYPE_LOG:
+		snap.Data, err = parseEntries(entries)
+	case STORETYPE_FEED:
+		snap.Data, err = parseEntries(feedEntries(entries))
+	case STORETYPE_COUNTER:
+		snap.Data = counterValue(entries)
+	default:
+		err = fmt.Errorf("db type error: %v", db.Type())
+	}
+
+	return
+}
+
+// entries中没有被其他entry的next引用的entry
+func headsOf(entries []ipfslog.Entry) []string {
+	referenced := map[string]struct{}{}
+	for _, e := range entries {
+		for _, next := range e.GetNext() {
+			referenced[next.String()] = struct{}{}
+		}
+	}
+
+	heads := []string{}
+	for _, e := range entries {
+		if _, ok := referenced[e.GetHash().String()]; !ok {
+			heads = append(heads, e.GetHash().String())
+		}
+	}
+	return heads
+}
+
+func rebuildKV(entries []ipfslog.Entry, key string) (interface{}, error) {
+	index := map[string][]byte{}
+	for _, e := range entries {
+		for _, change := range entryChanges(e) {
+			if change.op == "DEL" {
+				delete(index, change.key)
+			} else {
+				index[change.key] = change.value
+			}
+		}
+	}
+
+	if key != "" {
+		return index[key], nil
+	}
+	return index, nil
+}
+
+func rebuildDocs(entries []ipfslog.Entry, key string) (interface{}, error) {
+	index := map[string]interface{}{}
+	for _, e := range entries {
+		for _, change := range entryChanges(e) {
+			if change.op == "DEL" {
+				delete(index, change.key)
+				continue
+			}
+
+			var doc interface{}
+			if err := json.Unmarshal(change.value, &doc); err != nil {
+				return nil, err
+			}
+			index[change.key] = doc
+		}
+	}
+
+	if key != "" {
+		return index[key], nil
+	}
+
+	keys := make([]string, 0, len(index))
+	for k := range index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	docs := make([]interface{}, 0, len(index))
+	for _, k := range keys {
+		docs = append(docs, index[k])
+	}
+	return docs, nil
+}
+
+func parseEntries(entries []ipfslog.Entry) ([]operation.Operation, error) {
+	ops := make([]operation.Operation, 0, len(entries))
+	for _, e := range entries {
+		op, err := operation.ParseOperation(e)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
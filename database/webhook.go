@@ -0,0 +1,471 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	"berty.tech/go-orbit-db/stores/operation"
+)
+
+const WEBHOOKSDB = "self.webhooks"
+
+// 回调请求的签名头，值为 sha256=hex(hmac-sha256(secret, body))
+const WEBHOOK_SIGNATURE_HEADER = "X-DChannel-Signature"
+
+// 回调失败时的重试参数，第n次重试前等待 webhookBackoff*2^(n-1)，最长 webhookMaxBackoff
+const (
+	webhookRetries    = 5
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 5 * time.Minute
+	webhookTimeout    = 10 * time.Second
+
+	webhookQueueSize = 1024 //每个回调最多等待投递的事件数，队列满时丢弃新的事件
+)
+
+// Webhook 数据库变更的回调。
+// Ops 为需要回调的操作类型（如 PUT、DEL、ADD），为空表示全部；Prefix 为key前缀，为空表示全部。
+// Secret 用于签名，注册时为空会自动生成；实例设置了口令时加密保存
+type Webhook struct {
+	ID      string   `json:"id"`
+	Address string   `json:"address"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret,omitempty"`
+	Ops     []string `json:"ops"`
+	Prefix  string   `json:"prefix"`
+	Retries int      `json:"retries"` //失败后的最多重试次数，0使用默认值，小于0不重试
+}
+
+// WebhookEvent 回调请求的内容，每个entry回调一次
+type WebhookEvent struct {
+	Webhook string     `json:"webhook"`
+	Address string     `json:"address"`
+	Type    string     `json:"type"` //write 或 replicated
+	Op      string     `json:"op"`
+	Key     string     `json:"key,omitempty"`
+	Entry   *EntryInfo `json:"entry"`
+}
+
+// 只在本地保存的回调注册表
+func (ins *Instance) getWebhooksDB(ctx context.Context) (db orbitdb.KeyValueStore, err error) {
+	ins.hookLock.Lock()
+	defer ins.hookLock.Unlock()
+
+	localonly := true
+	if ins.Webhooks == nil && ins.OrbitDB != nil {
+		ins.Webhooks, err = ins.OrbitDB.KeyValue(ctx, WEBHOOKSDB, ins.storeOptions(&orbitdb.CreateDBOptions{
+			LocalOnly: &localonly,
+		}))
+		if err != nil {
+			return
+		}
+		err = ins.Webhooks.Load(ctx, -1)
+		if err != nil {
+			return
+		}
+	}
+	if ins.Webhooks == nil {
+		return nil, fmt.Errorf("orbitdb is not ready")
+	}
+
+	return ins.Webhooks, nil
+}
+
+// AddWebhook 注册回调，数据库已连接时立即开始回调
+func (ins *Instance) AddWebhook(ctx context.Context, hook Webhook) (Webhook, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return hook, fmt.Errorf("invalid webhook url: %s", hook.URL)
+	}
+	if hook.Address == "" {
+		return hook, fmt.Errorf("address is required")
+	}
+
+	if hook.ID, err = randomHex(8); err != nil {
+		return hook, err
+	}
+	if hook.Secret == "" {
+		if hook.Secret, err = randomHex(32); err != nil {
+			return hook, err
+		}
+	}
+
+	db, err := ins.getWebhooksDB(ctx)
+	if err != nil {
+		return hook, err
+	}
+
+	if err = ins.saveWebhook(ctx, db, hook); err != nil {
+		return hook, err
+	}
+
+	if store, ok := ins.ConnectingDB[hook.Address]; ok {
+		ins.startWebhook(store, hook)
+	}
+
+	return hook, nil
+}
+
+// RemoveWebhook 删除回调并停止投递
+func (ins *Instance) RemoveWebhook(ctx context.Context, id string) error {
+	db, err := ins.getWebhooksDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	value, err := db.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("webhook not found: %s", id)
+	}
+
+	ins.stopWebhook(id)
+	_, err = db.Delete(ctx, id)
+	return err
+}
+
+// ListWebhooks 列出回调，address不为空时只列出该数据库的回调。不返回secret
+func (ins *Instance) ListWebhooks(ctx context.Context, address string) ([]Webhook, error) {
+	hooks, err := ins.loadWebhooks(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, nil
+}
+
+func (ins *Instance) loadWebhooks(ctx context.Context, address string) ([]Webhook, error) {
+	db, err := ins.getWebhooksDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := []Webhook{}
+	for _, value := range db.All() {
+		hook := Webhook{}
+		if err = json.Unmarshal(value, &hook); err != nil {
+			return nil, err
+		}
+		if address != "" && hook.Address != address {
+			continue
+		}
+
+		if isSealedString(hook.Secret) {
+			if hook.Secret, err = openString(ins.box, hook.Secret); err != nil {
+				return nil, err
+			}
+		} else if ins.box != nil {
+			//设置口令之前保存的明文secret，加密后重新保存
+			if err = ins.saveWebhook(ctx, db, hook); err != nil {
+				return nil, err
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// 保存回调，有口令时secret加密保存
+func (ins *Instance) saveWebhook(ctx context.Context, db orbitdb.KeyValueStore, hook Webhook) (err error) {
+	if ins.box != nil {
+		if hook.Secret, err = sealString(ins.box, hook.Secret); err != nil {
+			return
+		}
+	}
+
+	value, err := json.Marshal(hook)
+	if err != nil {
+		return
+	}
+	_, err = db.Put(ctx, hook.ID, value)
+	return
+}
+
+// 数据库连接后开始它的所有回调
+func (ins *Instance) startWebhooks(db iface.Store) {
+	hooks, err := ins.loadWebhooks(ins.lifecircle_ctx, db.Address().String())
+	if err != nil {
+		return
+	}
+
+	for _, hook := range hooks {
+		ins.startWebhook(db, hook)
+	}
+}
+
+// 数据库关闭后停止它的所有回调
+func (ins *Instance) stopWebhooks(address string) {
+	ins.hookLock.Lock()
+	defer ins.hookLock.Unlock()
+
+	for id, h := range ins.hookRunners {
+		if h.address == address {
+			h.cancel()
+			delete(ins.hookRunners, id)
+		}
+	}
+}
+
+func (ins *Instance) stopWebhook(id string) {
+	ins.hookLock.Lock()
+	defer ins.hookLock.Unlock()
+
+	if h, ok := ins.hookRunners[id]; ok {
+		h.cancel()
+		delete(ins.hookRunners, id)
+	}
+}
+
+type hookRunner struct {
+	address string
+	cancel  context.CancelFunc
+}
+
+func (ins *Instance) startWebhook(db iface.Store, hook Webhook) {
+	ins.hookLock.Lock()
+	defer ins.hookLock.Unlock()
+
+	if _, ok := ins.hookRunners[hook.ID]; ok {
+		return
+	}
+
+	sub, err := db.EventBus().Subscribe([]interface{}{
+		new(stores.EventWrite),
+		new(stores.EventReplicated),
+	})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ins.lifecircle_ctx)
+	ins.hookRunners[hook.ID] = &hookRunner{address: hook.Address, cancel: cancel}
+
+	q := &webhookQueue{id: hook.ID, size: webhookQueueSize, signal: make(chan struct{}, 1)}
+
+	//接收事件不能阻塞数据库，先放入队列，再按顺序投递
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				typ, entries := webhookEntries(e)
+				for _, entry := range entries {
+					if we, ok := hook.event(typ, entry); ok {
+						q.push(we)
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		client := &http.Client{Timeout: webhookTimeout}
+		for {
+			we, ok := q.pop(ctx)
+			if !ok {
+				return
+			}
+			_ = hook.deliver(ctx, client, we)
+		}
+	}()
+}
+
+func webhookEntries(e interface{}) (string, []ipfslog.Entry) {
+	switch e := e.(type) {
+	case stores.EventWrite:
+		if e.Entry != nil {
+			return EVENT_WRITE, []ipfslog.Entry{e.Entry}
+		}
+	case stores.EventReplicated:
+		return EVENT_REPLICATED, e.Entries
+	}
+	return "", nil
+}
+
+// 按操作类型和key前缀过滤，生成回调内容。PUTALL 中任一文档ID匹配前缀即回调
+func (hook *Webhook) event(typ string, entry ipfslog.Entry) (we WebhookEvent, ok bool) {
+	op, err := operation.ParseOperation(entry)
+	if err != nil {
+		return we, false
+	}
+
+	if len(hook.Ops) > 0 {
+		matched := false
+		for _, o := range hook.Ops {
+			if strings.EqualFold(o, op.GetOperation()) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return we, false
+		}
+	}
+
+	key := ""
+	if op.GetKey() != nil {
+		key = *op.GetKey()
+	}
+	if hook.Prefix != "" {
+		matched := strings.HasPrefix(key, hook.Prefix)
+		for _, change := range entryChanges(entry) {
+			matched = matched || strings.HasPrefix(change.key, hook.Prefix)
+		}
+		if !matched {
+			return we, false
+		}
+	}
+
+	return WebhookEvent{
+		Webhook: hook.ID,
+		Address: hook.Address,
+		Type:    typ,
+		Op:      op.GetOperation(),
+		Key:     key,
+		Entry:   NewEntryInfo(entry),
+	}, true
+}
+
+// 投递一个回调，非2xx响应或请求失败时按指数退避重试
+func (hook *Webhook) deliver(ctx context.Context, client *http.Client, we WebhookEvent) error {
+	body, err := json.Marshal(we)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	retries := hook.Retries
+	if retries == 0 {
+		retries = webhookRetries
+	}
+
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err = post(ctx, client, hook.URL, body, signature)
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+func post(ctx context.Context, client *http.Client, u string, body []byte, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, signature)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// 固定长度的投递队列，接收事件时不阻塞。
+// 回调地址长时间不可用时队列会满，之后的事件丢弃并记录日志，不再占用更多内存
+type webhookQueue struct {
+	id      string
+	size    int
+	lock    sync.Mutex
+	items   []WebhookEvent
+	dropped int //队列满后丢弃的事件数，队列有空位时清零
+	signal  chan struct{}
+}
+
+func (q *webhookQueue) push(we WebhookEvent) {
+	q.lock.Lock()
+	if len(q.items) >= q.size {
+		q.dropped++
+		//只在开始丢弃时记录一次，避免写入频繁时日志过多
+		if q.dropped == 1 {
+			log.Printf("webhook %s: queue is full, dropping events", q.id)
+		}
+		q.lock.Unlock()
+		return
+	}
+	if q.dropped > 0 {
+		log.Printf("webhook %s: dropped %d events", q.id, q.dropped)
+		q.dropped = 0
+	}
+	q.items = append(q.items, we)
+	q.lock.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *webhookQueue) pop(ctx context.Context) (WebhookEvent, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.items) > 0 {
+			we := q.items[0]
+			q.items = q.items[1:]
+			q.lock.Unlock()
+			return we, true
+		}
+		q.lock.Unlock()
+
+		select {
+		case <-q.signal:
+		case <-ctx.Done():
+			return WebhookEvent{}, false
+		}
+	}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
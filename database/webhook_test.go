@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"d-channel/secret"
+	"strings"
+	"testing"
+)
+
+func (kv *memKV) All() map[string][]byte {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	all := make(map[string][]byte, len(kv.m))
+	for k, v := range kv.m {
+		all[k] = v
+	}
+	return all
+}
+
+// 有口令时回调的secret加密保存，读取时解密
+func TestWebhookSecretSealed(t *testing.T) {
+	ctx := context.Background()
+
+	box, err := secret.Unlock(t.TempDir(), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memKV{m: map[string][]byte{}}
+	ins := &Instance{Webhooks: store, box: box}
+
+	hook, err := ins.AddWebhook(ctx, Webhook{Address: "/orbitdb/bafy/db", URL: "http://127.0.0.1/hook", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(store.m[hook.ID]), "s3cret") {
+		t.Errorf("secret saved in plaintext: %s", store.m[hook.ID])
+	}
+
+	hooks, err := ins.loadWebhooks(ctx, "")
+	if err != nil || len(hooks) != 1 || hooks[0].Secret != "s3cret" {
+		t.Fatalf("load: %v %+v", err, hooks)
+	}
+
+	//没有口令时不能读取加密的secret
+	ins.box = nil
+	if _, err = ins.loadWebhooks(ctx, ""); err != secret.ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+}
+
+// 队列满时丢弃新的事件，有空位后继续接收
+func TestWebhookQueueFull(t *testing.T) {
+	q := &webhookQueue{id: "test", size: 2, signal: make(chan struct{}, 1)}
+	for _, key := range []string{"a", "b", "c"} {
+		q.push(WebhookEvent{Key: key})
+	}
+	if len(q.items) != 2 || q.dropped != 1 {
+		t.Fatalf("got %d items, %d dropped", len(q.items), q.dropped)
+	}
+
+	ctx := context.Background()
+	if we, _ := q.pop(ctx); we.Key != "a" {
+		t.Errorf("pop: got %q", we.Key)
+	}
+	q.push(WebhookEvent{Key: "d"})
+	if len(q.items) != 2 || q.dropped != 0 || q.items[1].Key != "d" {
+		t.Errorf("after pop: %+v, %d dropped", q.items, q.dropped)
+	}
+}
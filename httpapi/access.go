@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"context"
+	"d-channel/database"
+	"net/http"
+
+	"berty.tech/go-orbit-db/iface"
+	"github.com/gin-gonic/gin"
+)
+
+// 权限修改参数，capability 为 admin 或 write，id 为orbitdb身份ID
+type accessIn struct {
+	Address     string   `json:"address"`
+	OriginPeers []string `json:"originpeers"`
+
+	Capability string `json:"capability"`
+	ID         string `json:"id"`
+}
+
+// 授予权限
+func grant(c *gin.Context) {
+	changeAccess(c, database.Grant)
+}
+
+// 撤销权限
+func revoke(c *gin.Context) {
+	changeAccess(c, database.Revoke)
+}
+
+func changeAccess(c *gin.Context, change func(ctx context.Context, db iface.Store, capability string, id string) error) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &accessIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+	if in.ID == "" {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "id is required"})
+		return
+	}
+	if in.Capability == "" {
+		in.Capability = database.CAPABILITY_WRITE
+	}
+
+	db, err := connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	if err = change(c.Request.Context(), db, in.Capability, in.ID); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	list, err := database.GetACL(db)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: list})
+}
+
+// 查看数据库当前的访问控制列表
+func acl(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &accessIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	db, err := connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	list, err := database.GetACL(db)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: list})
+}
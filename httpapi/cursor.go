@@ -0,0 +1,124 @@
+package httpapi
+
+import (
+	"context"
+	"d-channel/database"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"berty.tech/go-orbit-db/iface"
+)
+
+// 分页结果，Next 为下一页的游标，没有下一页时为空
+type page struct {
+	Items interface{}
+	Next  string
+}
+
+// 分页参数，limit 为每页数量，cursor 为上一页返回的 next
+type pageIn struct {
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+}
+
+func (in *pageIn) paging() bool {
+	return in.Limit > 0 || in.Cursor != ""
+}
+
+// 游标对客户端是不透明的字符串，内容是base64编码的json
+func encodeCursor(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+	return nil
+}
+
+// KV的游标，记录上一页最后一个key
+type keyCursor struct {
+	Key string `json:"k"`
+}
+
+// 按key排序后分页，desc 为true时倒序。游标是上一页最后一个key，新增或删除其他key不影响已返回的位置
+func pageKeys(keys []string, in *pageIn, desc bool) (out []string, next string, err error) {
+	if desc {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+
+	if in.Cursor != "" {
+		cur := keyCursor{}
+		if err = decodeCursor(in.Cursor, &cur); err != nil {
+			return
+		}
+		keys = keys[sort.Search(len(keys), func(i int) bool {
+			if desc {
+				return keys[i] < cur.Key
+			}
+			return keys[i] > cur.Key
+		}):]
+	}
+
+	out = keys
+	if in.Limit > 0 && in.Limit < len(keys) {
+		out = keys[:in.Limit]
+		next, err = encodeCursor(keyCursor{Key: out[len(out)-1]})
+	}
+
+	return
+}
+
+// KV数据库的 all 分页，有索引时只读取本页的值
+func pageStore(ctx context.Context, rdb iface.KeyValueStore, in *pageIn) (*page, error) {
+	keys, next, ok, err := indexedKeys(ctx, rdb, database.KeyRange{Limit: in.Limit}, in)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return pageKV(rdb.All(), in)
+	}
+
+	items := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		value, err := rdb.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		items[k] = value
+	}
+
+	return &page{Items: items, Next: next}, nil
+}
+
+func pageKV(all map[string][]byte, in *pageIn) (p *page, err error) {
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+
+	keys, next, err := pageKeys(keys, in, false)
+	if err != nil {
+		return
+	}
+
+	items := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		items[k] = all[k]
+	}
+
+	return &page{Items: items, Next: next}, nil
+}
@@ -5,6 +5,7 @@ import (
 	"d-channel/database"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"berty.tech/go-orbit-db/iface"
 	"berty.tech/go-orbit-db/stores/operation"
@@ -14,6 +15,11 @@ import (
 // 执行数据库命令
 func exec(ctx context.Context, db iface.Store, method string, key string, value interface{}) (any interface{}, err error) {
 
+	//按历史时间点读取，适用于所有类型的数据库
+	if method == METHOD_asof {
+		return execAsOf(ctx, db, key, value)
+	}
+
 	//根据数据库类型字符串判断，进入不同的数据库命令函数
 	switch db.Type() {
 	case database.STORETYPE_KV: //KV 数据库
@@ -22,6 +28,10 @@ func exec(ctx context.Context, db iface.Store, method string, key string, value
 		any, err = execLog(ctx, db, method, key, value)
 	case database.STORETYPE_DOCS: //DOCS 数据库
 		any, err = execDocs(ctx, db, method, key, value)
+	case database.STORETYPE_COUNTER: //COUNTER 数据库
+		any, err = execCounter(ctx, db, method, key, value)
+	case database.STORETYPE_FEED: //FEED 数据库
+		any, err = execFeed(ctx, db, method, key, value)
 	default: //如果都不是，返回错误
 		err = fmt.Errorf("db type error: %v", db.Type())
 	}
@@ -30,18 +40,203 @@ func exec(ctx context.Context, db iface.Store, method string, key string, value
 		return
 	}
 
-	//当返回的类型是operation.Operation，拿到any序列化后的Json字符串，然后填充成Map[string]interface{}
-	if op, ok := any.(operation.Operation); ok {
-		var data []byte
-		data, err = op.Marshal()
+	//分页结果只转换其中的数据
+	if p, ok := any.(*page); ok {
+		p.Items, err = convertResult(ctx, p.Items)
+		return p, err
+	}
+
+	return convertResult(ctx, any)
+
+}
+
+// 当返回的类型是operation.Operation，拿到any序列化后的Json字符串，然后填充成Map[string]interface{}
+func convertResult(ctx context.Context, any interface{}) (interface{}, error) {
+	instance := instanceFrom(ctx)
+	any = openResult(instance, any) //加密数据库的值在这里解密
+
+	switch ops := any.(type) {
+	case operation.Operation:
+		return opToMap(instance, ops)
+	case []operation.Operation:
+		list := make([]map[string]interface{}, 0, len(ops))
+		for _, op := range ops {
+			m, err := opToMap(instance, op)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, m)
+		}
+		return list, nil
+	}
+
+	return any, nil
+}
+
+// 用截止到 value 中 heads/clock 的oplog重建只读数据，不影响正在使用的数据库
+func execAsOf(ctx context.Context, db iface.Store, key string, value interface{}) (any interface{}, err error) {
+	at := &database.AsOf{}
+	if err = decodeValue(value, at); err != nil {
+		return
+	}
+
+	var snap *database.Snapshot
+	snap, err = database.SnapshotAt(db, at, key)
+	if err != nil {
+		return
+	}
+
+	snap.Data, err = convertResult(ctx, snap.Data)
+	return snap, err
+}
+
+// 把operation转换为map，并附上所在entry的CID，加密的值用 instance 的密钥解密
+func opToMap(instance *database.Instance, op operation.Operation) (m map[string]interface{}, err error) {
+	var data []byte
+	data, err = op.Marshal()
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return
+	}
+
+	if e := op.GetEntry(); e != nil {
+		m["cid"] = e.GetHash().String()
+	}
+
+	if v := op.GetValue(); v != nil {
+		m["value"] = openOpValue(instance, v)
+	}
+
+	return
+}
+
+// 把command的value解析到结构体，value可以是json对象，也可以是json字符串（网页端提交的是字符串）
+func decodeValue(value interface{}, out interface{}) (err error) {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+		data = []byte(v)
+	default:
+		data, err = json.Marshal(v)
 		if err != nil {
 			return
 		}
-		err = json.Unmarshal(data, &any)
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// 范围查询的边界，为entry的CID
+type listBounds struct {
+	GT  string `json:"gt,omitempty"`
+	GTE string `json:"gte,omitempty"`
+	LT  string `json:"lt,omitempty"`
+	LTE string `json:"lte,omitempty"`
+}
+
+// 范围查询参数，reverse 为true时结果从新到旧排列。
+// amount 大于0时分页，cursor 为上一页返回的 next，会替换请求中的边界
+type listIn struct {
+	listBounds
+	Amount  *int   `json:"amount"`
+	Reverse bool   `json:"reverse"`
+	Cursor  string `json:"cursor"`
+}
+
+func (in *listIn) streamOptions() (opts *iface.StreamOptions, err error) {
+	opts = &iface.StreamOptions{Amount: in.Amount}
+
+	bounds := []struct {
+		s   string
+		dst **cid.Cid
+	}{
+		{in.GT, &opts.GT},
+		{in.GTE, &opts.GTE},
+		{in.LT, &opts.LT},
+		{in.LTE, &opts.LTE},
+	}
+	for _, b := range bounds {
+		if b.s == "" {
+			continue
+		}
+		var c cid.Cid
+		c, err = cid.Decode(b.s)
+		if err != nil {
+			return
+		}
+		*b.dst = &c
+	}
+
+	if opts.Amount == nil {
+		all := -1
+		opts.Amount = &all
 	}
 
 	return
+}
+
+// 按listIn的参数列出日志条目。
+// 有下界时向后翻页，下一页从本页最后一条之后开始；否则向前翻页，下一页在本页第一条之前结束
+func (in *listIn) list(ctx context.Context, lister func(context.Context, *iface.StreamOptions) ([]operation.Operation, error)) (p *page, err error) {
+	if in.Cursor != "" {
+		bounds := listBounds{}
+		if err = decodeCursor(in.Cursor, &bounds); err != nil {
+			return
+		}
+		in.listBounds = bounds
+	}
+
+	var opts *iface.StreamOptions
+	opts, err = in.streamOptions()
+	if err != nil {
+		return
+	}
+
+	//多取一条，用来判断是否还有下一页
+	paging := in.Amount != nil && *in.Amount > 0
+	if paging {
+		more := *in.Amount + 1
+		opts.Amount = &more
+	}
+
+	var ops []operation.Operation
+	ops, err = lister(ctx, opts)
+	if err != nil {
+		return
+	}
+
+	p = &page{}
+	if paging && len(ops) > *in.Amount {
+		next := listBounds{}
+		if in.GT != "" || in.GTE != "" {
+			ops = ops[:*in.Amount]
+			next = listBounds{GT: ops[len(ops)-1].GetEntry().GetHash().String(), LT: in.LT, LTE: in.LTE}
+		} else {
+			ops = ops[len(ops)-*in.Amount:]
+			next = listBounds{LT: ops[0].GetEntry().GetHash().String()}
+		}
+		p.Next, err = encodeCursor(next)
+		if err != nil {
+			return
+		}
+	}
+
+	if in.Reverse {
+		for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+			ops[i], ops[j] = ops[j], ops[i]
+		}
+	}
+	p.Items = ops
 
+	return
 }
 
 func execKV(ctx context.Context, db iface.Store, method string, key string, value interface{}) (any interface{}, err error) {
@@ -49,10 +244,18 @@ func execKV(ctx context.Context, db iface.Store, method string, key string, valu
 
 	switch method {
 	case METHOD_all:
-		any = rdb.All()
+		in := &pageIn{}
+		if err = decodeValue(value, in); err != nil {
+			return
+		}
+		if in.paging() {
+			any, err = pageStore(ctx, rdb, in)
+		} else {
+			any = rdb.All()
+		}
 	case METHOD_put:
 		var v []byte
-		v, err = json.Marshal(value) //参数value都解析成Json字符串数组
+		v, err = marshalValue(ctx, db, value)
 		if err != nil {
 			return
 		}
@@ -61,20 +264,65 @@ func execKV(ctx context.Context, db iface.Store, method string, key string, valu
 		any, err = rdb.Delete(ctx, key)
 	case METHOD_get:
 		any, err = rdb.Get(ctx, key)
+	case METHOD_putif:
+		in := &condIn{}
+		if err = decodeValue(value, in); err != nil {
+			return
+		}
+		var v []byte
+		v, err = marshalValue(ctx, db, in.Value)
+		if err != nil {
+			return
+		}
+		any, err = instanceFrom(ctx).CompareAndPut(ctx, rdb, key, v, &in.Condition)
+	case METHOD_deleteif:
+		in := &condIn{}
+		if err = decodeValue(value, in); err != nil {
+			return
+		}
+		any, err = instanceFrom(ctx).CompareAndDelete(ctx, rdb, key, &in.Condition)
+	case METHOD_scan:
+		in := &scanIn{}
+		if err = decodeValue(value, in); err != nil {
+			return
+		}
+		any, err = scanStore(ctx, rdb, in)
+	case METHOD_history:
+		any, err = database.KeyHistory(rdb, key)
+	case METHOD_latest:
+		_, op, found := database.LatestEntry(rdb, key)
+		if found {
+			any = op
+		}
 	default:
 		err = fmt.Errorf("method error: %v", method)
 	}
 
+	//本节点的写入立即更新有序key索引
+	switch method {
+	case METHOD_put, METHOD_delete, METHOD_putif, METHOD_deleteif:
+		if instance := instanceFrom(ctx); err == nil && instance != nil {
+			instance.IndexKey(ctx, rdb, key)
+		}
+	}
+
 	return
 
 }
+
+// 条件写入参数：{"value": 要写入的值, "entry": "最后看到的entry CID", "heads": ["..."]}
+type condIn struct {
+	database.Condition
+	Value interface{} `json:"value"`
+}
+
 func execLog(ctx context.Context, db iface.Store, method string, key string, value interface{}) (any interface{}, err error) {
 	rdb := db.(iface.EventLogStore)
 
 	switch method {
 	case METHOD_add:
 		var v []byte
-		v, err = json.Marshal(value)
+		v, err = marshalValue(ctx, db, value)
 		if err != nil {
 			return
 		}
@@ -86,6 +334,12 @@ func execLog(ctx context.Context, db iface.Store, method string, key string, val
 			return
 		}
 		any, err = rdb.Get(ctx, _cid)
+	case METHOD_list:
+		in := &listIn{}
+		if err = decodeValue(value, in); err != nil {
+			return
+		}
+		any, err = in.list(ctx, rdb.List)
 	default:
 		err = fmt.Errorf("method error: %v", method)
 	}
@@ -98,22 +352,119 @@ func execDocs(ctx context.Context, db iface.Store, method string, key string, va
 
 	switch method {
 	case METHOD_put:
-		any, err = rdb.Put(ctx, value)
+		var doc interface{}
+		doc, err = sealDoc(ctx, db, value)
+		if err != nil {
+			return
+		}
+		any, err = rdb.Put(ctx, doc)
+	case METHOD_putbatch, METHOD_putall:
+		var docs []interface{}
+		if err = decodeValue(value, &docs); err != nil || docs == nil {
+			err = fmt.Errorf("value must be an array of documents")
+			return
+		}
+		docs, err = sealDocs(ctx, db, docs)
+		if err != nil {
+			return
+		}
+		if method == METHOD_putbatch {
+			any, err = rdb.PutBatch(ctx, docs)
+		} else {
+			any, err = rdb.PutAll(ctx, docs)
+		}
 	case METHOD_get:
 		any, err = rdb.Get(ctx, key, nil)
 	case METHOD_delete:
 		any, err = rdb.Delete(ctx, key)
+	case METHOD_history:
+		any, err = database.KeyHistory(rdb, key)
 	case METHOD_query:
-		any, err = rdb.Query(ctx, func(doc interface{}) (bool, error) {
-			entity, ok := doc.(map[string]interface{})
-			if !ok {
-				return false, nil
-			}
-			if entity[key] == value {
-				return true, nil
+		var q *query
+		q, err = parseQuery(key, value)
+		if err != nil {
+			return
+		}
+		var docs []interface{}
+		docs, err = queryDocs(ctx, rdb, q)
+		if err != nil {
+			return
+		}
+		any, err = q.apply(docs)
+	default:
+		err = fmt.Errorf("method error: %v", method)
+	}
+
+	return
+}
+
+func execCounter(ctx context.Context, db iface.Store, method string, key string, value interface{}) (any interface{}, err error) {
+	rdb := db.(database.CounterStore)
+
+	switch method {
+	case METHOD_inc:
+		amount := 1 //没有提供value时，默认加1
+		if value != nil {
+			amount, err = toInt(value)
+			if err != nil {
+				return
 			}
-			return false, nil
-		})
+		}
+		any, err = rdb.Inc(ctx, amount)
+	case METHOD_value:
+		any = rdb.Value()
+	default:
+		err = fmt.Errorf("method error: %v", method)
+	}
+
+	return
+}
+
+// 把json解析出来的数字（float64）或数字字符串转换为int
+func toInt(value interface{}) (n int, err error) {
+	switch v := value.(type) {
+	case float64:
+		n = int(v)
+		if float64(n) != v {
+			err = fmt.Errorf("value is not an integer: %v", v)
+		}
+	case string:
+		n, err = strconv.Atoi(v)
+	default:
+		err = fmt.Errorf("value type error: %T", value)
+	}
+
+	return
+}
+
+func execFeed(ctx context.Context, db iface.Store, method string, key string, value interface{}) (any interface{}, err error) {
+	rdb := db.(database.FeedStore)
+
+	switch method {
+	case METHOD_add:
+		var v []byte
+		v, err = marshalValue(ctx, db, value)
+		if err != nil {
+			return
+		}
+		any, err = rdb.Add(ctx, v)
+	case METHOD_get, METHOD_remove:
+		var _cid cid.Cid
+		_cid, err = cid.Decode(key)
+		if err != nil {
+			return
+		}
+		if method == METHOD_get {
+			any, err = rdb.Get(ctx, _cid)
+		} else {
+			any, err = rdb.Remove(ctx, _cid)
+		}
+	case METHOD_list:
+		in := &listIn{}
+		if err = decodeValue(value, in); err != nil {
+			return
+		}
+		any, err = in.list(ctx, rdb.List)
 	default:
 		err = fmt.Errorf("method error: %v", method)
 	}
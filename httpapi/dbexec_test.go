@@ -0,0 +1,45 @@
+package httpapi
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/operation"
+)
+
+// 记录 PutBatch 收到的文档
+type batchDocs struct {
+	iface.DocumentStore
+	docs []interface{}
+}
+
+func (d *batchDocs) PutBatch(ctx context.Context, docs []interface{}) (operation.Operation, error) {
+	d.docs = docs
+	return nil, nil
+}
+
+func TestExecDocsPutBatch(t *testing.T) {
+	want := []interface{}{map[string]interface{}{"_id": "1", "name": "alice"}}
+
+	//网页端提交的是json字符串，其他客户端提交的是json数组
+	for _, value := range []interface{}{
+		`[{"_id": "1", "name": "alice"}]`,
+		[]interface{}{map[string]interface{}{"_id": "1", "name": "alice"}},
+	} {
+		db := &batchDocs{}
+		if _, err := execDocs(context.Background(), db, METHOD_putbatch, "", value); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(db.docs, want) {
+			t.Errorf("%v: got %v, want %v", value, db.docs, want)
+		}
+	}
+
+	for _, value := range []interface{}{nil, `{"_id": "1"}`} {
+		if _, err := execDocs(context.Background(), &batchDocs{}, METHOD_putbatch, "", value); err == nil {
+			t.Errorf("%v: expected error", value)
+		}
+	}
+}
@@ -2,17 +2,22 @@ package httpapi
 
 import (
 	"context"
+	"d-channel/config"
 	"d-channel/database"
+	"d-channel/secret"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 
 	"berty.tech/go-orbit-db/iface"
 	"github.com/gin-gonic/gin"
 	cors "github.com/rs/cors/wrapper/gin"
 )
 
-// 单例，数据库实例
-var instance *database.Instance
+// 启动参数，来自默认值、配置文件和命令行，/boot 请求中的字段覆盖它
+var bootConfig = config.Default()
 
 // 返回消息的的类型
 const (
@@ -20,6 +25,8 @@ const (
 	MSG_FAIL    = "fail"
 	MSG_UNKNOW  = "unknow"
 	MSG_ERROR   = "error"
+
+	MSG_CONFLICT = "conflict" //条件写入时数据已被其他entry修改
 )
 
 // 方法名称，
@@ -30,42 +37,180 @@ const (
 	METHOD_add    = "add"
 	METHOD_delete = "delete"
 	METHOD_query  = "query"
+	METHOD_inc    = "inc"
+	METHOD_value  = "value"
+	METHOD_remove = "remove"
+	METHOD_list   = "list"
+
+	METHOD_putbatch = "putbatch"
+	METHOD_putall   = "putall"
+
+	METHOD_putif    = "putif"
+	METHOD_deleteif = "deleteif"
+	METHOD_latest   = "latest"
+	METHOD_scan     = "scan"
+	METHOD_history  = "history"
+	METHOD_asof     = "asof"
 )
 
-// 响应的数据结构
+// 响应的数据结构，Next 为分页查询的下一页游标
 type response struct {
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
+	Next    string      `json:"next,omitempty"`
+}
+
+// 运行HTTP接口，cfg 为启动实例的默认参数，在 cfg.Port 端口监听
+func Run(cfg *config.Config) error {
+	return newRouter(cfg).Run(":" + cfg.Port)
+}
+
+// Handler HTTP接口的handler，用于嵌入其他服务或在测试中使用 httptest
+func Handler(cfg *config.Config) http.Handler {
+	return newRouter(cfg)
 }
 
-// 运行HTTP接口
-func Run(addr string) error {
+func newRouter(cfg *config.Config) *gin.Engine {
+	bootConfig = cfg
 
 	router := gin.Default()
 	// router.SetTrustedProxies([]string{"127.0.0.1", "localhost"})
 	router.SetTrustedProxies(nil)
 	router.Use(cors.AllowAll())          // 开启 CORS
-	router.POST("/boot", bootInstance)   // 启动实例
-	router.POST("/programs", programs)   // 查看实例内置数据库，其中包含所有数据库信息
-	router.POST("/close", closeInstance) //关闭实例
-	router.POST("/createdb", createdb)   //创建数据库
-	router.POST("/removedb", removedb)   //移除数据库
-	router.POST("/closedb", closedb)     //关闭数据库
-	router.POST("/command", command)     //执行数据库操作命令
+	router.Use(selectInstance)           // 按路径或请求头选择实例
+	router.POST("/instances", instances) // 列出已启动的实例
+
+	//不带前缀的请求使用请求头指定的实例或 default 实例，/i/<name>/ 前缀使用名为name的实例
+	routes(router)
+	routes(router.Group("/i/:" + INSTANCE_PARAM))
+
+	return router
+}
+
+// 注册实例的接口
+func routes(r gin.IRoutes) {
+	r.POST("/boot", bootInstance)             // 启动实例
+	r.POST("/programs", programs)             // 查看实例内置数据库，其中包含所有数据库信息
+	r.POST("/close", closeInstance)           //关闭实例
+	r.POST("/lock", lockInstance)             //锁定实例，清除内存中的密钥
+	r.POST("/passphrase", passphrase)         //修改密钥口令
+	r.POST("/swarmkey", swarmkey)             //导出私有网络的swarm key
+	r.POST("/createdb", createdb)             //创建数据库
+	r.POST("/removedb", removedb)             //移除数据库
+	r.POST("/closedb", closedb)               //关闭数据库
+	r.POST("/command", command)               //执行数据库操作命令
+	r.POST("/batch", batch)                   //批量执行数据库操作命令
+	r.POST("/heads", heads)                   //查看数据库oplog的heads
+	r.POST("/entry", entry)                   //查看oplog中的一个entry
+	r.POST("/oplog", oplog)                   //分页遍历数据库oplog
+	r.POST("/status", status)                 //查看数据库的同步状态
+	r.POST("/dbinfos", dbinfos)               //列出所有数据库信息及同步状态
+	r.POST("/addwebhook", addwebhook)         //注册数据库变更回调
+	r.POST("/removewebhook", removewebhook)   //删除回调
+	r.POST("/webhooks", webhooks)             //列出回调
+	r.POST("/grant", grant)                   //授予数据库权限
+	r.POST("/revoke", revoke)                 //撤销数据库权限
+	r.POST("/acl", acl)                       //查看数据库的访问控制列表
+	r.POST("/recipient", recipient)           //查看本节点的age公钥
+	r.POST("/setrecipients", setrecipients)   //设置数据库的加密接收者
+	r.POST("/opendb", opendb)                 //用指定身份打开数据库
+	r.POST("/identities", identities)         //列出身份
+	r.POST("/createidentity", createidentity) //创建命名身份
+	r.POST("/selectidentity", selectidentity) //选择默认使用的身份
+	r.POST("/exportidentity", exportidentity) //导出身份
+	r.POST("/importidentity", importidentity) //导入身份
+	r.GET("/subscribe", subscribe)            //订阅数据库事件（Server-Sent Events）
+	r.GET("/subscribe/ws", subscribeWS)       //订阅数据库事件（WebSocket）
 
-	return router.Run(addr)
 }
 
-// 启动实例，运行成功过后，赋值给全局变量 instance
+// 启动参数，passphrase 用于解锁加密的密钥，第一次提供时会加密密钥。
+// private/swarmkey/generateswarmkey 用于加入私有网络。
+// repo/dbpath/listen/announce/bootstrap/routing/profiles/offline 不为空时覆盖配置文件和命令行的值
+type bootIn struct {
+	Passphrase string `json:"passphrase"`
+
+	Private          bool   `json:"private"`
+	SwarmKey         string `json:"swarmkey"`
+	GenerateSwarmKey bool   `json:"generateswarmkey"`
+
+	RepoPath  string   `json:"repo"`
+	DBPath    string   `json:"dbpath"`
+	Listen    []string `json:"listen"`
+	Announce  []string `json:"announce"`
+	Bootstrap []string `json:"bootstrap"`
+	Routing   string   `json:"routing"`
+	Profiles  []string `json:"profiles"`
+	Offline   *bool    `json:"offline"` //没有设置时使用配置文件的值
+
+	Identity string `json:"identity"` //启动后选择的身份名称，为空时使用默认身份
+}
+
+// 启动实例，运行成功过后，按名称保存在 instances 中。
+// 名称不是 default 的实例必须有自己的 repo 和 dbpath
 func bootInstance(c *gin.Context) {
+	bootLock.Lock()
+	defer bootLock.Unlock()
+
+	name := c.GetString(INSTANCE_PARAM)
+	instance := getInstance(name)
 	if instance == nil {
+		in := &bootIn{}
+		//没有请求体时按不加密启动
+		if err := c.ShouldBindJSON(in); err != nil && err != io.EOF {
+			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+			return
+		}
+
+		cfg := bootConfig.ForInstance(name).Merge(&config.Config{
+			RepoPath:  in.RepoPath,
+			DBPath:    in.DBPath,
+			Listen:    in.Listen,
+			Announce:  in.Announce,
+			Bootstrap: in.Bootstrap,
+			Routing:   in.Routing,
+			Profiles:  in.Profiles,
+			Offline:   in.Offline,
+		})
+		if name != DEFAULT_INSTANCE && (cfg.RepoPath == config.DEFAULT_PATH || cfg.DBPath == config.DEFAULT_PATH) {
+			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "instance " + name + " needs its own repo and dbpath"})
+			return
+		}
+
+		if err := checkPaths(name, cfg.RepoPath, cfg.DBPath); err != nil {
+			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+			return
+		}
+
 		var err error
-		instance, err = database.BootInstance(context.Background(), database.DEFAULT_PATH, database.DEFAULT_PATH)
+		instance, err = database.BootInstance(context.Background(), &database.Options{
+			RepoPath:         cfg.RepoPath,
+			DBPath:           cfg.DBPath,
+			Listen:           cfg.Listen,
+			Announce:         cfg.Announce,
+			Bootstrap:        cfg.Bootstrap,
+			Routing:          cfg.Routing,
+			Profiles:         cfg.Profiles,
+			Offline:          cfg.IsOffline(),
+			Passphrase:       in.Passphrase,
+			Private:          in.Private,
+			SwarmKey:         in.SwarmKey,
+			GenerateSwarmKey: in.GenerateSwarmKey,
+		})
 		if err != nil {
 			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
 			return
 		}
 
+		if in.Identity != "" {
+			if err = instance.SelectIdentity(c.Request.Context(), in.Identity); err != nil {
+				instance.Close()
+				c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+				return
+			}
+		}
+
+		setInstance(name, instance)
 	}
 
 	c.JSON(http.StatusOK,
@@ -74,13 +219,42 @@ func bootInstance(c *gin.Context) {
 			Data: map[string]string{
 				"peerID":    instance.IPFSNode.Identity.String(),
 				"orbitdbID": instance.OrbitDB.Identity().ID,
+				"private":   strconv.FormatBool(instance.IPFSNode.PNetFingerprint != nil),
+				"offline":   strconv.FormatBool(instance.Offline),
+				"instance":  name,
 			},
 		},
 	)
 }
 
-// 关闭实例，关闭成功后，instance为空
+// 导出私有网络的swarm key，其他节点用它加入同一个私有网络
+func swarmkey(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance nil"})
+		return
+	}
+
+	key, err := instance.SwarmKey()
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+	if key == "" {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is not in a private network"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: key})
+}
+
+// 关闭实例，关闭成功后从 instances 中移除
 func closeInstance(c *gin.Context) {
+	bootLock.Lock()
+	defer bootLock.Unlock()
+
+	name := c.GetString(INSTANCE_PARAM)
+	instance := getInstance(name)
 	if instance == nil {
 		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance nil"})
 		return
@@ -90,7 +264,53 @@ func closeInstance(c *gin.Context) {
 		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
 		return
 	}
-	instance = nil
+	setInstance(name, nil)
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS})
+}
+
+// 锁定实例：关闭实例和IPFS节点，清除内存中的密钥
+func lockInstance(c *gin.Context) {
+	bootLock.Lock()
+	defer bootLock.Unlock()
+
+	name := c.GetString(INSTANCE_PARAM)
+	instance := getInstance(name)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance nil"})
+		return
+	}
+	err := instance.Lock()
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+	setInstance(name, nil)
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS})
+}
+
+type passphraseIn struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// 修改密钥口令
+func passphrase(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance nil"})
+		return
+	}
+
+	in := &passphraseIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	if err := instance.ChangePassphrase(in.Old, in.New); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS})
 }
 
@@ -99,11 +319,19 @@ type createIn struct {
 	Name      string   `json:"name"`
 	StoreType string   `json:"storetype"`
 	AccessIDs []string `json:"accessids"`
+
+	ACType   string   `json:"actype"`   //访问控制类型 ipfs（默认）或 orbitdb
+	AdminIDs []string `json:"adminids"` //orbitdb 访问控制的管理员，为空时为创建者
+
+	Recipients []string `json:"recipients"` //不为空时创建加密数据库，值为age公钥
+
+	Identity string `json:"identity"` //写入使用的身份名称，为空时使用当前选择的身份
 }
 
 // 创建数据库
 func createdb(c *gin.Context) {
 
+	instance := currentInstance(c)
 	if instance == nil {
 		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
 		return
@@ -117,13 +345,31 @@ func createdb(c *gin.Context) {
 		return
 	}
 
-	_, err = instance.CreateDB(c.Request.Context(), in.Name, in.StoreType, in.AccessIDs)
+	//先检查接收者，避免数据库创建后才发现公钥错误
+	if _, err = secret.ParseRecipients(in.Recipients); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	db, err := instance.CreateDB(c.Request.Context(), in.Name, in.StoreType, database.ACL{
+		Type:  in.ACType,
+		Admin: in.AdminIDs,
+		Write: in.AccessIDs,
+	}, in.Identity)
 	if err != nil {
 		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS})
+	if len(in.Recipients) > 0 {
+		err = instance.SetRecipients(c.Request.Context(), db.Address().String(), in.Recipients)
+		if err != nil {
+			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: db.Address().String()})
 
 }
 
@@ -142,6 +388,7 @@ type commandIn struct {
 func command(c *gin.Context) {
 
 	log.Println("command")
+	instance := currentInstance(c)
 	if instance == nil {
 		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
 		return
@@ -156,29 +403,122 @@ func command(c *gin.Context) {
 		return
 	}
 
+	db, err = connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	//客户端要求NDJSON时，以流的方式返回结果
+	if wantNDJSON(c) {
+		streamCommand(c, db, in)
+		return
+	}
+
+	//执行数据库操作命令。
+	c.JSON(http.StatusOK, runCommand(c.Request.Context(), db, in))
+}
+
+// 取得要操作的数据库
+func connectDB(ctx context.Context, address string, originPeers []string) (db iface.Store, err error) {
+	instance := instanceFrom(ctx)
+	if instance == nil {
+		return nil, errors.New("instance is null")
+	}
+
 	//检查是否是连接中的数据库
-	db, connecting := instance.ConnectingDB[in.Address]
+	db, connecting := instance.ConnectingDB[address]
 	//如果不是，连接并添加数据库（添加动作也会覆盖已经保存过的数据库，如果地址相同）
 	if !connecting {
-		db, err = instance.OpenDB(c.Request.Context(), in.Address, in.OriginPeers)
-		if err != nil {
-			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
-			return
+		db, err = instance.OpenDB(ctx, address, originPeers, "")
+	}
+	return
+}
+
+// 批量命令的执行模式
+const (
+	BATCH_STOP     = "stop"     //遇到错误即停止，后面的命令不执行
+	BATCH_CONTINUE = "continue" //遇到错误继续执行后面的命令
+)
+
+// 批量命令参数，mode 默认为 stop
+type batchIn struct {
+	Commands []commandIn `json:"commands"`
+	Mode     string      `json:"mode"`
+}
+
+// 按顺序执行多条命令，可以操作不同的数据库。
+// data 中按顺序返回每条命令的结果，未执行的命令 message 为 unknow
+func batch(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	var err error
+
+	in := &batchIn{}
+	if err = c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	switch in.Mode {
+	case "":
+		in.Mode = BATCH_STOP
+	case BATCH_STOP, BATCH_CONTINUE:
+	default:
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "mode error: " + in.Mode})
+		return
+	}
+
+	message := MSG_SUCCESS
+	results := make([]response, len(in.Commands))
+	for i := range in.Commands {
+		if message != MSG_SUCCESS && in.Mode == BATCH_STOP {
+			results[i] = response{Message: MSG_UNKNOW, Data: "skipped"}
+			continue
+		}
+
+		results[i] = execCommand(c.Request.Context(), &in.Commands[i])
+		if results[i].Message != MSG_SUCCESS {
+			message = MSG_FAIL
 		}
 	}
 
-	//执行数据库操作命令。
-	result, err := exec(c.Request.Context(), db, in.Method, in.Key, in.Value)
+	c.JSON(http.StatusOK, response{Message: message, Data: results})
+}
+
+// 连接数据库并执行一条命令，返回这条命令的结果
+func execCommand(ctx context.Context, in *commandIn) response {
+	db, err := connectDB(ctx, in.Address, in.OriginPeers)
 	if err != nil {
-		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "exec err:" + err.Error()})
-		return
+		return response{Message: MSG_ERROR, Data: "open err:" + err.Error()}
+	}
+
+	return runCommand(ctx, db, in)
+}
+
+// 在数据库上执行一条命令，返回这条命令的结果
+func runCommand(ctx context.Context, db iface.Store, in *commandIn) response {
+	result, err := exec(ctx, db, in.Method, in.Key, in.Value)
+	if errors.Is(err, database.ErrConflict) {
+		return response{Message: MSG_CONFLICT, Data: err.Error()}
+	}
+	if err != nil {
+		return response{Message: MSG_ERROR, Data: "exec err:" + err.Error()}
 	}
 
-	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: result})
+	if p, ok := result.(*page); ok {
+		return response{Message: MSG_SUCCESS, Data: p.Items, Next: p.Next}
+	}
+	return response{Message: MSG_SUCCESS, Data: result}
 }
 
 // 获取程序内置数据库，以便于获得其他库的信息。
 func programs(c *gin.Context) {
+	instance := currentInstance(c)
 	if instance == nil {
 		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance nil"})
 		return
@@ -199,6 +539,7 @@ type removeIn struct {
 
 // 删除数据库
 func removedb(c *gin.Context) {
+	instance := currentInstance(c)
 	if instance == nil {
 		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
 		return
@@ -226,6 +567,7 @@ type closeIn struct {
 
 // 关闭数据库
 func closedb(c *gin.Context) {
+	instance := currentInstance(c)
 	if instance == nil {
 		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
 		return
@@ -0,0 +1,176 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type identityIn struct {
+	Name string `json:"name"`
+}
+
+// 列出默认身份和所有命名身份
+func identities(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	list, err := instance.ListIdentities(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: list})
+}
+
+// 创建命名身份，返回身份ID，用于加入其他数据库的写入列表
+func createidentity(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &identityIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	identity, err := instance.CreateIdentity(c.Request.Context(), in.Name)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: identity})
+}
+
+// 选择创建和打开数据库时默认使用的身份
+func selectidentity(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &identityIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	if err := instance.SelectIdentity(c.Request.Context(), in.Name); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS})
+}
+
+type opendbIn struct {
+	Address     string   `json:"address"`
+	OriginPeers []string `json:"originpeers"`
+	Identity    string   `json:"identity"`
+}
+
+// 用指定身份打开数据库，之后的命令都使用这个身份写入。
+// 数据库已经用其他身份打开时，需要先关闭
+func opendb(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &opendbIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	if db, ok := instance.ConnectingDB[in.Address]; ok {
+		if in.Identity != "" {
+			identity, err := instance.Identity(c.Request.Context(), in.Identity)
+			if err != nil {
+				c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+				return
+			}
+			if db.Identity().ID != identity.ID {
+				c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "db is opened with another identity, close it first"})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: db.Address().String()})
+		return
+	}
+
+	db, err := instance.OpenDB(c.Request.Context(), in.Address, in.OriginPeers, in.Identity)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: db.Address().String()})
+}
+
+type exportIn struct {
+	Name       string `json:"name"`
+	Passphrase string `json:"passphrase"`
+}
+
+// 导出身份和密钥，返回用口令加密的文本
+func exportidentity(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &exportIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	bundle, err := instance.ExportIdentity(c.Request.Context(), in.Name, in.Passphrase)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: string(bundle)})
+}
+
+type importIn struct {
+	Bundle     string `json:"bundle"`
+	Passphrase string `json:"passphrase"`
+	Name       string `json:"name"` //为空时使用导出时的名称
+}
+
+// 导入其他实例导出的身份
+func importidentity(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &importIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	identity, err := instance.ImportIdentity(c.Request.Context(), []byte(in.Bundle), in.Passphrase, in.Name)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: identity})
+}
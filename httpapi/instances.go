@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"context"
+	"d-channel/database"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 实例的选择：路径 /i/<name>/... 优先，其次是请求头，都没有时使用 default 实例
+const (
+	DEFAULT_INSTANCE = "default"
+	INSTANCE_PARAM   = "instance"
+	INSTANCE_HEADER  = "X-DChannel-Instance"
+)
+
+var instanceName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	runningLock sync.RWMutex
+	running     = map[string]*database.Instance{} //已启动的实例，按名称保存
+
+	bootLock sync.Mutex //启动、关闭、锁定实例依次执行
+)
+
+type instanceKey struct{}
+
+func getInstance(name string) *database.Instance {
+	runningLock.RLock()
+	defer runningLock.RUnlock()
+	return running[name]
+}
+
+// 保存实例，ins 为nil时移除
+func setInstance(name string, ins *database.Instance) {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if ins == nil {
+		delete(running, name)
+		return
+	}
+	running[name] = ins
+}
+
+// 中间件：确定请求的实例名称，并把实例放入请求的context，后面的函数用 instanceFrom 取得
+func selectInstance(c *gin.Context) {
+	name := c.Param(INSTANCE_PARAM)
+	if name == "" {
+		name = c.GetHeader(INSTANCE_HEADER)
+	}
+	if name == "" {
+		name = DEFAULT_INSTANCE
+	}
+	if !instanceName.MatchString(name) {
+		c.AbortWithStatusJSON(http.StatusOK, response{Message: MSG_ERROR, Data: "invalid instance name: " + name})
+		return
+	}
+
+	c.Set(INSTANCE_PARAM, name)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), instanceKey{}, getInstance(name)))
+	c.Next()
+}
+
+// 请求选择的实例，没有启动时为nil
+func currentInstance(c *gin.Context) *database.Instance {
+	return instanceFrom(c.Request.Context())
+}
+
+func instanceFrom(ctx context.Context) *database.Instance {
+	ins, _ := ctx.Value(instanceKey{}).(*database.Instance)
+	return ins
+}
+
+// 列出已启动的实例
+func instances(c *gin.Context) {
+	runningLock.RLock()
+	list := make([]map[string]string, 0, len(running))
+	for name, ins := range running {
+		list = append(list, map[string]string{
+			"instance":  name,
+			"peerID":    ins.IPFSNode.Identity.String(),
+			"orbitdbID": ins.OrbitDB.Identity().ID,
+		})
+	}
+	runningLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i]["instance"] < list[j]["instance"] })
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: list})
+}
+
+// 启动前检查目录：两个实例使用同一个repo或orbitdb目录会争用文件锁，甚至共用leveldb
+func checkPaths(name, repoPath, dbpath string) error {
+	repoPath, dbpath, err := database.ResolvePaths(repoPath, dbpath)
+	if err != nil {
+		return err
+	}
+
+	runningLock.RLock()
+	defer runningLock.RUnlock()
+
+	for other, ins := range running {
+		if other == name {
+			continue
+		}
+		if ins.Repo == repoPath {
+			return fmt.Errorf("repo %s is used by instance %s", repoPath, other)
+		}
+		if ins.Dir == dbpath {
+			return fmt.Errorf("dbpath %s is used by instance %s", dbpath, other)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package httpapi
+
+import (
+	"d-channel/database"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSelectInstance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	alice := &database.Instance{}
+	setInstance("alice", alice)
+	defer setInstance("alice", nil)
+
+	router := gin.New()
+	router.Use(selectInstance)
+	handler := func(c *gin.Context) {
+		running := "false"
+		if currentInstance(c) != nil {
+			running = "true"
+		}
+		c.String(http.StatusOK, c.GetString(INSTANCE_PARAM)+" "+running)
+	}
+	router.POST("/status", handler)
+	router.Group("/i/:"+INSTANCE_PARAM).POST("/status", handler)
+
+	cases := []struct {
+		path, header, want string
+	}{
+		{"/status", "", "default false"},
+		{"/status", "alice", "alice true"},
+		{"/i/bob/status", "alice", "bob false"},
+		{"/i/alice/status", "", "alice true"},
+		{"/status", "../alice", MSG_ERROR},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set(INSTANCE_HEADER, tc.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s (header %q): got %q, want %q", tc.path, tc.header, w.Body.String(), tc.want)
+		}
+	}
+}
+
+// 不同实例不能使用同一个目录，相对路径按绝对路径比较
+func TestCheckPaths(t *testing.T) {
+	dir := t.TempDir()
+	alice := &database.Instance{Repo: filepath.Join(dir, "alice", "repo"), Dir: filepath.Join(dir, "alice", "db")}
+	setInstance("alice", alice)
+	defer setInstance("alice", nil)
+
+	cases := []struct {
+		name, repo, db string
+		ok             bool
+	}{
+		{"bob", filepath.Join(dir, "bob", "repo"), filepath.Join(dir, "bob", "db"), true},
+		{"bob", filepath.Join(dir, "alice", "repo"), filepath.Join(dir, "bob", "db"), false},
+		{"bob", filepath.Join(dir, "bob", "repo"), filepath.Join(dir, "alice", "..", "alice", "db"), false},
+		{"alice", alice.Repo, alice.Dir, true},
+	}
+
+	for _, tc := range cases {
+		if err := checkPaths(tc.name, tc.repo, tc.db); (err == nil) != tc.ok {
+			t.Errorf("%s %s %s: got %v, want ok=%v", tc.name, tc.repo, tc.db, err, tc.ok)
+		}
+	}
+}
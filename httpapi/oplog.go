@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"d-channel/database"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oplog查看参数，cid 用于 entry，limit 和 cursor 用于 oplog 分页
+type oplogIn struct {
+	Address     string   `json:"address"`
+	OriginPeers []string `json:"originpeers"`
+
+	CID string `json:"cid"`
+	pageIn
+}
+
+// 查看数据库oplog的heads
+func heads(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &oplogIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	db, err := connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: database.LogHeads(db)})
+}
+
+// 查看oplog中的一个entry，包括payload、next、时钟、身份和签名
+func entry(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &oplogIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	db, err := connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	info, err := database.LogEntry(db, in.CID)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: info})
+}
+
+// 从新到旧分页遍历oplog
+func oplog(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &oplogIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	db, err := connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	cur := keyCursor{}
+	if in.Cursor != "" {
+		if err = decodeCursor(in.Cursor, &cur); err != nil {
+			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+			return
+		}
+	}
+
+	infos, more, err := database.WalkLog(db, cur.Key, in.Limit)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	next := ""
+	if more {
+		next, err = encodeCursor(keyCursor{Key: infos[len(infos)-1].CID})
+		if err != nil {
+			c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: infos, Next: next})
+}
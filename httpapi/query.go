@@ -0,0 +1,470 @@
+package httpapi
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// docstore 查询语句，例如：
+//
+//	{
+//		"filter": {"$or": [{"age": {"$gt": 18}}, {"profile.vip": true}], "name": {"$regex": "^a"}},
+//		"sort": ["-age", "name"],
+//		"skip": 0,
+//		"limit": 10,
+//		"fields": ["name", "profile.city"],
+//		"cursor": ""
+//	}
+//
+// filter 中同一层的条件是 AND 关系，字段名可以用 . 访问嵌套字段和数组下标，
+// 字段值不是操作符对象时等同于 $eq。sort 中字段名前加 - 表示倒序。
+// 分页时把上一次返回的 next 作为 cursor 传入，skip 只作用于第一页。
+type query struct {
+	Filter map[string]interface{} `json:"filter"`
+	Sort   []string               `json:"sort"`
+	Skip   int                    `json:"skip"`
+	Limit  int                    `json:"limit"`
+	Fields []string               `json:"fields"`
+	Cursor string                 `json:"cursor"`
+
+	match matcher
+}
+
+// 判断文档是否满足条件
+type matcher func(doc interface{}) bool
+
+// 解析查询参数，key不为空时兼容旧的用法：匹配 key 字段等于 value 的文档
+func parseQuery(key string, value interface{}) (q *query, err error) {
+	q = &query{}
+	if key != "" {
+		q.Filter = map[string]interface{}{key: map[string]interface{}{"$eq": value}}
+	} else if err = decodeValue(value, q); err != nil {
+		return
+	}
+
+	if q.Skip < 0 || q.Limit < 0 {
+		err = fmt.Errorf("skip and limit can not be negative")
+		return
+	}
+
+	q.match, err = compileFilter(q.Filter)
+	return
+}
+
+// Match 作为 DocumentStore.Query 的过滤函数
+func (q *query) Match(doc interface{}) (bool, error) {
+	return q.match(doc), nil
+}
+
+// 查询的游标，记录上一页最后一个文档的排序值和_id
+type queryCursor struct {
+	Sort []interface{} `json:"s"`
+	ID   interface{}   `json:"i"`
+}
+
+// 对过滤后的文档排序、分页和投影。
+// 提供 limit 或 cursor 时返回分页结果，文档按 sort 和 _id 排序，游标指向上一页最后一个文档，
+// 因此翻页过程中插入或删除文档不会造成重复或遗漏
+func (q *query) apply(docs []interface{}) (any interface{}, err error) {
+	paging := q.Limit > 0 || q.Cursor != ""
+
+	if len(q.Sort) > 0 || paging {
+		sort.SliceStable(docs, func(i, j int) bool {
+			return q.compareKeys(q.sortKey(docs[i]), q.sortKey(docs[j])) < 0
+		})
+	}
+
+	if q.Cursor != "" {
+		cur := queryCursor{}
+		if err = decodeCursor(q.Cursor, &cur); err != nil {
+			return
+		}
+		if len(cur.Sort) != len(q.Sort) {
+			err = fmt.Errorf("invalid cursor: sort fields changed")
+			return
+		}
+		key := append(cur.Sort, cur.ID)
+		docs = docs[sort.Search(len(docs), func(i int) bool {
+			return q.compareKeys(q.sortKey(docs[i]), key) > 0
+		}):]
+	}
+
+	//skip 只作用于第一页，之后的页从游标位置开始
+	if q.Cursor == "" {
+		if q.Skip >= len(docs) {
+			docs = docs[:0]
+		} else {
+			docs = docs[q.Skip:]
+		}
+	}
+
+	next := ""
+	if q.Limit > 0 && q.Limit < len(docs) {
+		docs = docs[:q.Limit]
+		if paging {
+			key := q.sortKey(docs[len(docs)-1])
+			next, err = encodeCursor(queryCursor{Sort: key[:len(q.Sort)], ID: key[len(q.Sort)]})
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if len(q.Fields) > 0 {
+		for i, doc := range docs {
+			docs[i] = project(doc, q.Fields)
+		}
+	}
+
+	if paging {
+		return &page{Items: docs, Next: next}, nil
+	}
+	return docs, nil
+}
+
+// 文档的排序值：sort 中各字段的值，最后是_id
+func (q *query) sortKey(doc interface{}) []interface{} {
+	key := make([]interface{}, 0, len(q.Sort)+1)
+	for _, s := range q.Sort {
+		v, _ := lookup(doc, strings.TrimPrefix(s, "-"))
+		key = append(key, v)
+	}
+	id, _ := lookup(doc, "_id")
+	return append(key, id)
+}
+
+func (q *query) compareKeys(a, b []interface{}) int {
+	for i := range a {
+		c := sortCompare(a[i], b[i])
+		if c == 0 {
+			continue
+		}
+		if i < len(q.Sort) && strings.HasPrefix(q.Sort[i], "-") {
+			return -c
+		}
+		return c
+	}
+	return 0
+}
+
+func compileFilter(filter map[string]interface{}) (matcher, error) {
+	matchers := make([]matcher, 0, len(filter))
+
+	for k, v := range filter {
+		var m matcher
+		var err error
+
+		switch k {
+		case "$and", "$or":
+			m, err = compileLogical(k, v)
+		case "$not":
+			sub, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$not requires an object")
+			}
+			var inner matcher
+			if inner, err = compileFilter(sub); err == nil {
+				m = func(doc interface{}) bool { return !inner(doc) }
+			}
+		default:
+			if strings.HasPrefix(k, "$") {
+				return nil, fmt.Errorf("unknown operator: %s", k)
+			}
+			m, err = compileField(k, v)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	return func(doc interface{}) bool {
+		for _, m := range matchers {
+			if !m(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileLogical(op string, v interface{}) (matcher, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s requires an array", op)
+	}
+
+	matchers := make([]matcher, 0, len(list))
+	for _, item := range list {
+		sub, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s requires an array of objects", op)
+		}
+		m, err := compileFilter(sub)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	if op == "$and" {
+		return func(doc interface{}) bool {
+			for _, m := range matchers {
+				if !m(doc) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+
+	return func(doc interface{}) bool {
+		for _, m := range matchers {
+			if m(doc) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// 字段条件，cond 是 {"$op": arg} 形式的对象或者直接是要相等的值
+func compileField(path string, cond interface{}) (matcher, error) {
+	ops, ok := cond.(map[string]interface{})
+	if !ok || !isOperatorObject(ops) {
+		return func(doc interface{}) bool {
+			v, found := lookup(doc, path)
+			return found && equal(v, cond)
+		}, nil
+	}
+
+	matchers := make([]func(v interface{}, found bool) bool, 0, len(ops))
+	for op, arg := range ops {
+		arg := arg
+		switch op {
+		case "$eq":
+			matchers = append(matchers, func(v interface{}, found bool) bool {
+				return found && equal(v, arg)
+			})
+		case "$ne":
+			matchers = append(matchers, func(v interface{}, found bool) bool {
+				return !found || !equal(v, arg)
+			})
+		case "$gt", "$gte", "$lt", "$lte":
+			op := op
+			matchers = append(matchers, func(v interface{}, found bool) bool {
+				if !found {
+					return false
+				}
+				c, ok := compare(v, arg)
+				if !ok {
+					return false
+				}
+				switch op {
+				case "$gt":
+					return c > 0
+				case "$gte":
+					return c >= 0
+				case "$lt":
+					return c < 0
+				default:
+					return c <= 0
+				}
+			})
+		case "$in":
+			list, ok := arg.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$in requires an array")
+			}
+			matchers = append(matchers, func(v interface{}, found bool) bool {
+				if !found {
+					return false
+				}
+				for _, item := range list {
+					if equal(v, item) {
+						return true
+					}
+				}
+				return false
+			})
+		case "$exists":
+			exists, ok := arg.(bool)
+			if !ok {
+				return nil, fmt.Errorf("$exists requires a boolean")
+			}
+			matchers = append(matchers, func(_ interface{}, found bool) bool {
+				return found == exists
+			})
+		case "$regex":
+			pattern, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("$regex requires a string")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, func(v interface{}, found bool) bool {
+				s, ok := v.(string)
+				return found && ok && re.MatchString(s)
+			})
+		default:
+			return nil, fmt.Errorf("unknown operator: %s", op)
+		}
+	}
+
+	return func(doc interface{}) bool {
+		v, found := lookup(doc, path)
+		for _, m := range matchers {
+			if !m(v, found) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// 对象的所有key都以$开头时才认为是操作符对象，否则按普通值比较
+func isOperatorObject(m map[string]interface{}) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// 按 . 分隔的路径取值，数组可以用数字下标
+func lookup(doc interface{}, path string) (interface{}, bool) {
+	cur := doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func equal(a, b interface{}) bool {
+	if fa, ok := a.(float64); ok {
+		fb, ok := b.(float64)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 比较两个同类型的值（数字、字符串、布尔），类型不同时 ok 为 false
+func compare(a, b interface{}) (c int, ok bool) {
+	switch va := a.(type) {
+	case float64:
+		vb, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case va < vb:
+			return -1, true
+		case va > vb:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		vb, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(va, vb), true
+	case bool:
+		vb, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case va == vb:
+			return 0, true
+		case !va:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// 排序用的比较，不可比较的值按类型排序：空值 < 布尔 < 数字 < 字符串 < 其他
+func sortCompare(a, b interface{}) int {
+	if c, ok := compare(a, b); ok {
+		return c
+	}
+	ra, rb := typeRank(a), typeRank(b)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	return 0
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+// 只保留 fields 中的字段，嵌套字段保留原来的层级
+func project(doc interface{}, fields []string) interface{} {
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return doc
+	}
+
+	out := map[string]interface{}{}
+	for _, field := range fields {
+		v, found := lookup(doc, field)
+		if !found {
+			continue
+		}
+
+		parts := strings.Split(field, ".")
+		cur := out
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := cur[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				cur[part] = next
+			}
+			cur = next
+		}
+		cur[parts[len(parts)-1]] = v
+	}
+
+	return out
+}
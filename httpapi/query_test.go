@@ -0,0 +1,178 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testDocs(t *testing.T) []interface{} {
+	var docs []interface{}
+	err := json.Unmarshal([]byte(`[
+		{"_id": "1", "name": "alice", "age": 30, "profile": {"city": "beijing", "vip": true}, "tags": ["a", "b"]},
+		{"_id": "2", "name": "bob", "age": 17, "profile": {"city": "shanghai"}},
+		{"_id": "3", "name": "carol", "age": 25, "profile": {"city": "beijing", "vip": false}},
+		{"_id": "4", "name": "dave"}
+	]`), &docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return docs
+}
+
+func ids(docs []interface{}) []string {
+	out := []string{}
+	for _, doc := range docs {
+		out = append(out, doc.(map[string]interface{})["_id"].(string))
+	}
+	return out
+}
+
+func TestQueryFilter(t *testing.T) {
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{`{"filter": {"name": "bob"}}`, []string{"2"}},
+		{`{"filter": {"age": {"$gt": 18}}}`, []string{"1", "3"}},
+		{`{"filter": {"age": {"$gte": 17, "$lt": 30}}}`, []string{"2", "3"}},
+		{`{"filter": {"age": {"$ne": 30}}}`, []string{"2", "3", "4"}},
+		{`{"filter": {"profile.city": "beijing"}}`, []string{"1", "3"}},
+		{`{"filter": {"profile.vip": {"$exists": true}}}`, []string{"1", "3"}},
+		{`{"filter": {"age": {"$exists": false}}}`, []string{"4"}},
+		{`{"filter": {"name": {"$in": ["alice", "dave"]}}}`, []string{"1", "4"}},
+		{`{"filter": {"name": {"$regex": "^[bc]"}}}`, []string{"2", "3"}},
+		{`{"filter": {"tags.1": "b"}}`, []string{"1"}},
+		{`{"filter": {"$or": [{"age": {"$lt": 18}}, {"profile.vip": true}]}}`, []string{"1", "2"}},
+		{`{"filter": {"$and": [{"profile.city": "beijing"}, {"age": {"$lt": 30}}]}}`, []string{"3"}},
+		{`{"filter": {"$not": {"profile.city": "beijing"}}}`, []string{"2", "4"}},
+		{`{}`, []string{"1", "2", "3", "4"}},
+	}
+
+	for _, c := range cases {
+		q, err := parseQuery("", c.query)
+		if err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+
+		got := []interface{}{}
+		for _, doc := range testDocs(t) {
+			if ok, _ := q.Match(doc); ok {
+				got = append(got, doc)
+			}
+		}
+		if !reflect.DeepEqual(ids(got), c.want) {
+			t.Errorf("%s: got %v, want %v", c.query, ids(got), c.want)
+		}
+	}
+}
+
+func TestQueryLegacyKey(t *testing.T) {
+	q, err := parseQuery("name", "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, _ := q.Match(testDocs(t)[2])
+	if !ok {
+		t.Error("expected legacy key/value query to match")
+	}
+}
+
+func TestQueryApply(t *testing.T) {
+	q, err := parseQuery("", map[string]interface{}{
+		"sort":   []interface{}{"-age"},
+		"skip":   1,
+		"limit":  2,
+		"fields": []interface{}{"_id", "profile.city"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := q.apply(testDocs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		map[string]interface{}{"_id": "3", "profile": map[string]interface{}{"city": "beijing"}},
+		map[string]interface{}{"_id": "2", "profile": map[string]interface{}{"city": "shanghai"}},
+	}
+	if !reflect.DeepEqual(got.(*page).Items, want) {
+		t.Errorf("got %v, want %v", got.(*page).Items, want)
+	}
+}
+
+func TestQueryCursor(t *testing.T) {
+	docs := testDocs(t)
+	cursor := ""
+	pages := [][]string{}
+
+	for {
+		q, err := parseQuery("", map[string]interface{}{"sort": []interface{}{"name"}, "limit": 3, "cursor": cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := q.apply(append([]interface{}{}, docs...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := got.(*page)
+		pages = append(pages, ids(p.Items.([]interface{})))
+
+		if p.Next == "" {
+			break
+		}
+		cursor = p.Next
+
+		//翻页过程中插入排在前面的文档，不影响后面的页
+		docs = append(docs, map[string]interface{}{"_id": "0", "name": "aaron"})
+	}
+
+	want := [][]string{{"1", "2", "3"}, {"4"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("got %v, want %v", pages, want)
+	}
+}
+
+func TestQueryCursorSkip(t *testing.T) {
+	cursor := ""
+	pages := [][]string{}
+
+	for {
+		q, err := parseQuery("", map[string]interface{}{"sort": []interface{}{"name"}, "skip": 1, "limit": 2, "cursor": cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := q.apply(testDocs(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := got.(*page)
+		pages = append(pages, ids(p.Items.([]interface{})))
+
+		if p.Next == "" {
+			break
+		}
+		cursor = p.Next
+	}
+
+	//skip 只跳过第一页前面的文档，第二页紧接着第一页
+	want := [][]string{{"2", "3"}, {"4"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("got %v, want %v", pages, want)
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	for _, s := range []string{
+		`{"filter": {"age": {"$foo": 1}}}`,
+		`{"filter": {"$or": {"age": 1}}}`,
+		`{"filter": {"name": {"$regex": "("}}}`,
+		`{"limit": -1}`,
+	} {
+		if _, err := parseQuery("", s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"context"
+	"d-channel/database"
+	"strings"
+
+	"berty.tech/go-orbit-db/iface"
+)
+
+// 扫描参数，返回 key 以 prefix 开头并且在 [start, end) 范围内的记录，
+// 按key排序，reverse 为true时倒序，limit 和 cursor 用于分页
+type scanIn struct {
+	pageIn
+	Prefix  string `json:"prefix"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Reverse bool   `json:"reverse"`
+}
+
+// 扫描KV数据库。已连接的数据库使用实例维护的有序key索引，只读取本页的值；
+// 没有索引时读取全部记录后过滤排序，每次请求的开销为 O(n log n)
+func scanStore(ctx context.Context, rdb iface.KeyValueStore, in *scanIn) (*page, error) {
+	r := database.KeyRange{Prefix: in.Prefix, Start: in.Start, End: in.End, Reverse: in.Reverse, Limit: in.Limit}
+	keys, next, ok, err := indexedKeys(ctx, rdb, r, &in.pageIn)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return scanKV(rdb.All(), in)
+	}
+
+	items := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		value, err := rdb.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, map[string]interface{}{"key": k, "value": value})
+	}
+
+	return &page{Items: items, Next: next}, nil
+}
+
+// 按索引取出一页key，ok 为false表示数据库没有索引
+func indexedKeys(ctx context.Context, rdb iface.KeyValueStore, r database.KeyRange, in *pageIn) (keys []string, next string, ok bool, err error) {
+	instance := instanceFrom(ctx)
+	if instance == nil {
+		return
+	}
+
+	if in.Cursor != "" {
+		cur := keyCursor{}
+		if err = decodeCursor(in.Cursor, &cur); err != nil {
+			return
+		}
+		r.After = cur.Key
+	}
+
+	var more bool
+	keys, more, ok = instance.ScanKeys(rdb.Address().String(), r)
+	if ok && more && len(keys) > 0 {
+		next, err = encodeCursor(keyCursor{Key: keys[len(keys)-1]})
+	}
+	return
+}
+
+// 扫描全部记录，结果是按顺序排列的 {"key":...,"value":...} 列表
+func scanKV(all map[string][]byte, in *scanIn) (p *page, err error) {
+	keys := make([]string, 0)
+	for k := range all {
+		if !strings.HasPrefix(k, in.Prefix) {
+			continue
+		}
+		if in.Start != "" && k < in.Start {
+			continue
+		}
+		if in.End != "" && k >= in.End {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	keys, next, err := pageKeys(keys, &in.pageIn, in.Reverse)
+	if err != nil {
+		return
+	}
+
+	items := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, map[string]interface{}{"key": k, "value": all[k]})
+	}
+
+	return &page{Items: items, Next: next}, nil
+}
@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func scanKeys(items interface{}) []string {
+	out := []string{}
+	for _, item := range items.([]map[string]interface{}) {
+		out = append(out, item["key"].(string))
+	}
+	return out
+}
+
+func TestScanKV(t *testing.T) {
+	all := map[string][]byte{
+		"user:1:name":    []byte("a"),
+		"user:1:profile": []byte("b"),
+		"user:2:name":    []byte("c"),
+		"user:3:name":    []byte("d"),
+		"group:1:name":   []byte("e"),
+	}
+
+	cases := []struct {
+		in   scanIn
+		want []string
+	}{
+		{scanIn{Prefix: "user:1:"}, []string{"user:1:name", "user:1:profile"}},
+		{scanIn{Prefix: "user:", Start: "user:2", End: "user:3"}, []string{"user:2:name"}},
+		{scanIn{Prefix: "user:", Reverse: true, pageIn: pageIn{Limit: 2}}, []string{"user:3:name", "user:2:name"}},
+		{scanIn{Prefix: "nobody:"}, []string{}},
+	}
+
+	for _, c := range cases {
+		p, err := scanKV(all, &c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scanKeys(p.Items); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%+v: got %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestScanKVCursor(t *testing.T) {
+	all := map[string][]byte{"a": nil, "b": nil, "c": nil, "d": nil, "e": nil}
+
+	for _, reverse := range []bool{false, true} {
+		got := []string{}
+		in := &scanIn{Reverse: reverse, pageIn: pageIn{Limit: 2}}
+		for {
+			p, err := scanKV(all, in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, scanKeys(p.Items)...)
+			if p.Next == "" {
+				break
+			}
+			in.Cursor = p.Next
+		}
+
+		want := []string{"a", "b", "c", "d", "e"}
+		if reverse {
+			want = []string{"e", "d", "c", "b", "a"}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("reverse=%v: got %v, want %v", reverse, got, want)
+		}
+	}
+}
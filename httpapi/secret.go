@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"d-channel/database"
+	"encoding/json"
+	"net/http"
+
+	"berty.tech/go-orbit-db/iface"
+	"github.com/gin-gonic/gin"
+)
+
+// 把命令的value序列化为json，加密数据库再加密
+func marshalValue(ctx context.Context, db iface.Store, value interface{}) ([]byte, error) {
+	v, err := json.Marshal(value) //参数value都解析成Json字符串数组
+	if err != nil {
+		return nil, err
+	}
+	instance := instanceFrom(ctx)
+	if instance == nil {
+		return v, nil
+	}
+	return instance.Seal(ctx, db.Address().String(), v)
+}
+
+// 加密数据库的文档只保留 _id，其余内容加密
+func sealDoc(ctx context.Context, db iface.Store, doc interface{}) (interface{}, error) {
+	instance := instanceFrom(ctx)
+	if instance == nil {
+		return doc, nil
+	}
+	return instance.SealDoc(ctx, db.Address().String(), doc)
+}
+
+func sealDocs(ctx context.Context, db iface.Store, docs []interface{}) ([]interface{}, error) {
+	sealed := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		s, err := sealDoc(ctx, db, doc)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, s)
+	}
+	return sealed, nil
+}
+
+// 解密读取到的值，不是密文时原样返回
+func openValue(instance *database.Instance, data []byte) []byte {
+	if instance == nil || data == nil {
+		return data
+	}
+	return instance.Open(data)
+}
+
+func openDoc(instance *database.Instance, doc interface{}) interface{} {
+	if instance == nil {
+		return doc
+	}
+	return instance.OpenDoc(doc)
+}
+
+func openAll(instance *database.Instance, all map[string][]byte) map[string][]byte {
+	out := make(map[string][]byte, len(all))
+	for k, v := range all {
+		out[k] = openValue(instance, v)
+	}
+	return out
+}
+
+type recipientsIn struct {
+	Address     string   `json:"address"`
+	OriginPeers []string `json:"originpeers"`
+	Recipients  []string `json:"recipients"`
+}
+
+// 查看本节点的age公钥，其他节点把它加入接收者列表后，本节点可以读取加密数据库
+func recipient(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	r, err := instance.Recipient()
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS, Data: r})
+}
+
+// 设置数据库的接收者列表，之后本节点写入的值会加密。
+// 数据库中已有加密的entry时，列表不能为空
+func setrecipients(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	in := &recipientsIn{}
+	if err := c.ShouldBindJSON(in); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "bind err:" + err.Error()})
+		return
+	}
+
+	db, err := connectDB(c.Request.Context(), in.Address, in.OriginPeers)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: "open err:" + err.Error()})
+		return
+	}
+
+	if err = instance.SetRecipients(c.Request.Context(), db.Address().String(), in.Recipients); err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response{Message: MSG_SUCCESS})
+}
+
+// 解密命令结果中的值
+func openResult(instance *database.Instance, any interface{}) interface{} {
+	switch v := any.(type) {
+	case []byte:
+		return openValue(instance, v)
+	case map[string][]byte:
+		return openAll(instance, v)
+	case map[string]interface{}:
+		return openDoc(instance, v)
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, doc := range v {
+			out = append(out, openDoc(instance, doc))
+		}
+		return out
+	case []map[string]interface{}: //scan 的结果
+		for _, item := range v {
+			if b, ok := item["value"].([]byte); ok {
+				item["value"] = openValue(instance, b)
+			}
+		}
+		return v
+	case []database.Version:
+		for i := range v {
+			v[i].Value = openOpValue(instance, v[i].Value)
+		}
+		return v
+	}
+
+	return any
+}
+
+// 解密operation中的值，docstore的值是加密后的文档json
+func openOpValue(instance *database.Instance, value []byte) []byte {
+	if !bytes.Contains(value, []byte(database.SECRET_FIELD)) {
+		return openValue(instance, value)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return value
+	}
+	if _, _, ok := database.SealedDoc(doc); !ok {
+		return openValue(instance, value)
+	}
+	opened, err := json.Marshal(openDoc(instance, doc))
+	if err != nil {
+		return value
+	}
+	return opened
+}
+
+// 在查询的过滤函数中先解密，再按条件匹配，返回解密后的文档
+func queryDocs(ctx context.Context, rdb iface.DocumentStore, q *query) ([]interface{}, error) {
+	instance := instanceFrom(ctx)
+	docs := []interface{}{}
+	_, err := rdb.Query(ctx, func(doc interface{}) (bool, error) {
+		doc = openDoc(instance, doc)
+		ok, err := q.Match(doc)
+		if ok && err == nil {
+			docs = append(docs, doc)
+		}
+		return false, err
+	})
+	return docs, err
+}
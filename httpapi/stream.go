@@ -0,0 +1,214 @@
+package httpapi
+
+import (
+	"context"
+	"d-channel/database"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/operation"
+	"github.com/gin-gonic/gin"
+)
+
+// 请求头 Accept 中包含该类型时，command 以NDJSON流的方式返回结果
+const MIME_NDJSON = "application/x-ndjson"
+
+func wantNDJSON(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), MIME_NDJSON)
+}
+
+// 以NDJSON流的方式执行命令，每条结果写一行，不在内存中拼装完整的结果。
+// 出错时最后一行为 {"message":"error","data":"..."}
+func streamCommand(c *gin.Context, db iface.Store, in *commandIn) {
+	c.Header("Content-Type", MIME_NDJSON)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	emit := func(v interface{}) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	err := streamExec(c.Request.Context(), db, in.Method, in.Key, in.Value, emit)
+	if err != nil {
+		_ = emit(response{Message: MSG_ERROR, Data: "exec err:" + err.Error()})
+	}
+}
+
+// 支持流式读取的命令逐条输出，其他命令执行后把结果作为一行输出
+func streamExec(ctx context.Context, db iface.Store, method string, key string, value interface{}, emit func(interface{}) error) (err error) {
+
+	switch {
+	case db.Type() == database.STORETYPE_KV && method == METHOD_all:
+		return streamKV(instanceFrom(ctx), db.(iface.KeyValueStore).All(), emit)
+	case db.Type() == database.STORETYPE_LOG && method == METHOD_list:
+		return streamLog(ctx, db.(iface.EventLogStore), value, emit)
+	case db.Type() == database.STORETYPE_DOCS && method == METHOD_query:
+		return streamDocs(ctx, db.(iface.DocumentStore), key, value, emit)
+	}
+
+	var result interface{}
+	result, err = exec(ctx, db, method, key, value)
+	if err != nil {
+		return
+	}
+
+	//列表结果逐条输出
+	if p, ok := result.(*page); ok {
+		result = p.Items
+	}
+	switch items := result.(type) {
+	case []map[string]interface{}:
+		for _, item := range items {
+			if err = emit(item); err != nil {
+				return
+			}
+		}
+		return
+	case []interface{}:
+		for _, item := range items {
+			if err = emit(item); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	return emit(result)
+}
+
+// KV按key排序逐条输出 {"key":...,"value":...}。
+// 值在输出时逐个解密，不复制整个store，额外的内存只有排序用的key列表
+func streamKV(instance *database.Instance, all map[string][]byte, emit func(interface{}) error) error {
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := emit(map[string]interface{}{"key": k, "value": openValue(instance, all[k])}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 日志使用 EventLogStore.Stream 边读边输出。
+// 需要倒序或分页时与非流式的 list 相同，先取出本页结果再输出
+func streamLog(ctx context.Context, rdb iface.EventLogStore, value interface{}, emit func(interface{}) error) (err error) {
+	in := &listIn{}
+	if err = decodeValue(value, in); err != nil {
+		return
+	}
+
+	if in.Reverse || in.Cursor != "" || (in.Amount != nil && *in.Amount > 0) {
+		var p *page
+		p, err = in.list(ctx, rdb.List)
+		if err != nil {
+			return
+		}
+		for _, op := range p.Items.([]operation.Operation) {
+			var m map[string]interface{}
+			if m, err = opToMap(instanceFrom(ctx), op); err != nil {
+				return
+			}
+			if err = emit(m); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	var opts *iface.StreamOptions
+	opts, err = in.streamOptions()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan operation.Operation)
+	done := make(chan error, 1)
+	go func() {
+		done <- rdb.Stream(ctx, ch, opts)
+	}()
+
+	for op := range ch {
+		if err != nil {
+			continue //出错后继续读完，让Stream退出
+		}
+		var m map[string]interface{}
+		if m, err = opToMap(instanceFrom(ctx), op); err == nil {
+			err = emit(m)
+		}
+		if err != nil {
+			cancel()
+		}
+	}
+
+	if streamErr := <-done; err == nil {
+		err = streamErr
+	}
+
+	return
+}
+
+// 文档查询在过滤函数中直接输出匹配的文档，不保存结果。
+// 需要排序或分页时只能先取出全部结果
+func streamDocs(ctx context.Context, rdb iface.DocumentStore, key string, value interface{}, emit func(interface{}) error) (err error) {
+	var q *query
+	q, err = parseQuery(key, value)
+	if err != nil {
+		return
+	}
+
+	if len(q.Sort) > 0 || q.Limit > 0 || q.Cursor != "" {
+		var docs []interface{}
+		docs, err = queryDocs(ctx, rdb, q)
+		if err != nil {
+			return
+		}
+
+		var result interface{}
+		result, err = q.apply(docs)
+		if err != nil {
+			return
+		}
+		if p, ok := result.(*page); ok {
+			result = p.Items
+		}
+		for _, doc := range result.([]interface{}) {
+			if err = emit(doc); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	skip := q.Skip
+	_, err = rdb.Query(ctx, func(doc interface{}) (bool, error) {
+		doc = openDoc(instanceFrom(ctx), doc)
+		if !q.match(doc) {
+			return false, nil
+		}
+		if skip > 0 {
+			skip--
+			return false, nil
+		}
+		if len(q.Fields) > 0 {
+			doc = project(doc, q.Fields)
+		}
+		return false, emit(doc)
+	})
+
+	return
+}
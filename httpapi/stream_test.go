@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/operation"
+)
+
+// 只实现 List 的日志，Stream 不应被调用
+type listLog struct {
+	iface.EventLogStore
+	ops      []operation.Operation
+	streamed bool
+}
+
+func (l *listLog) List(ctx context.Context, opts *iface.StreamOptions) ([]operation.Operation, error) {
+	return append([]operation.Operation{}, l.ops...), nil
+}
+
+func (l *listLog) Stream(ctx context.Context, ch chan operation.Operation, opts *iface.StreamOptions) error {
+	l.streamed = true
+	close(ch)
+	return nil
+}
+
+func TestStreamLogReverse(t *testing.T) {
+	log := &listLog{}
+	for _, v := range []string{"a", "b", "c"} {
+		log.ops = append(log.ops, operation.NewOperation(nil, "ADD", []byte(v)))
+	}
+
+	got := []string{}
+	err := streamLog(context.Background(), log, map[string]interface{}{"reverse": true}, func(v interface{}) error {
+		got = append(got, string(v.(map[string]interface{})["value"].([]byte)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if log.streamed {
+		t.Error("reverse list should not use Stream")
+	}
+	if want := []string{"c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
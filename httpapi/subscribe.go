@@ -0,0 +1,106 @@
+package httpapi
+
+import (
+	"d-channel/database"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// websocket使用默认的同源检查：没有Origin的请求（非浏览器客户端）或与Host相同的来源才能连接，
+// 避免任意网页通过浏览器连接本地接口
+var upgrader = websocket.Upgrader{}
+
+// 订阅数据库事件，通过查询参数 address 指定数据库，originpeers 为逗号分隔的节点ID。
+// 以Server-Sent Events推送，事件名为事件类型，数据为 database.DBEvent。
+// 收到 lagged 事件时说明有事件被丢弃，客户端应重新读取数据
+func subscribe(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	events, err := subscribeEvents(c)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Type, ev)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// 与 subscribe 相同，通过websocket推送，每条消息为一个 database.DBEvent 的json
+func subscribeWS(c *gin.Context) {
+	instance := currentInstance(c)
+	if instance == nil {
+		c.JSON(http.StatusOK, response{Message: MSG_FAIL, Data: "instance is null"})
+		return
+	}
+
+	events, err := subscribeEvents(c)
+	if err != nil {
+		c.JSON(http.StatusOK, response{Message: MSG_ERROR, Data: err.Error()})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	//读取客户端消息，以便发现连接关闭
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err = conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// 按请求参数连接数据库并订阅事件，请求结束时取消订阅
+func subscribeEvents(c *gin.Context) (<-chan database.DBEvent, error) {
+	var originPeers []string
+	if peers := c.Query("originpeers"); peers != "" {
+		originPeers = strings.Split(peers, ",")
+	}
+
+	db, err := connectDB(c.Request.Context(), c.Query("address"), originPeers)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.SubscribeDB(c.Request.Context(), db)
+}
@@ -1,35 +1,74 @@
 package main
 
 import (
+	"d-channel/config"
 	"d-channel/httpapi"
 	"flag"
 	"fmt"
+	"log"
 	"os"
 )
 
 func main() {
 
 	// 定义命令行参数
+	configFile := flag.String("c", "", "The config file (.toml, .yaml or .yml).")
 	port := flag.String("p", "", "The port to listen on.")
+	repoPath := flag.String("repo", "", "The ipfs repo path, \"default\" for the kubo default.")
+	dbPath := flag.String("dbpath", "", "The orbitdb directory, \"default\" for ~/orbitdb.")
+	listen := flag.String("listen", "", "Comma separated ipfs swarm listen addresses.")
+	announce := flag.String("announce", "", "Comma separated ipfs announce addresses.")
+	bootstrap := flag.String("bootstrap", "", "Comma separated bootstrap peers.")
+	routing := flag.String("routing", "", "The routing mode: client (default), server, auto or none.")
+	profile := flag.String("profile", "", "Comma separated kubo config profiles applied when the repo is created, e.g. server,lowpower,test,local-discovery.")
+	offline := flag.Bool("offline", false, "Boot without network, databases are local only.")
 
 	// 解析命令行参数
 	flag.Parse()
 
-	// 如果命令行参数不存在，则从环境变量中读取
-	if *port == "" {
-		*port = os.Getenv("DAPPPORT")
-	}
+	// 优先级从低到高：默认值、配置文件、环境变量、命令行参数，见 config 包的说明
+	cfg := config.Default()
 
-	// 如果还是不存在，则使用默认值
-	if *port == "" {
-		*port = "8000"
+	if *configFile != "" {
+		file, err := config.Load(*configFile)
+		if err != nil {
+			log.Fatalf("load config: %s", err)
+		}
+		cfg.Merge(file)
 	}
 
+	cfg.Merge(&config.Config{Port: os.Getenv(config.PORT_ENV)})
+
+	cfg.Merge(&config.Config{
+		Port:      *port,
+		RepoPath:  *repoPath,
+		DBPath:    *dbPath,
+		Listen:    config.SplitList(*listen),
+		Announce:  config.SplitList(*announce),
+		Bootstrap: config.SplitList(*bootstrap),
+		Routing:   *routing,
+		Profiles:  config.SplitList(*profile),
+		Offline:   offlineFlag(offline),
+	})
+
 	// 输出结果
-	fmt.Printf("Listening on port %s...\n", *port)
+	fmt.Printf("Listening on port %s...\n", cfg.Port)
 
 	// 程序继续执行...
 
-	httpapi.Run(fmt.Sprintf(":%s", *port))
+	if err := httpapi.Run(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+}
 
+// 命令行中有 -offline 时返回它的值，否则为nil，不覆盖配置文件
+func offlineFlag(offline *bool) *bool {
+	var set *bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "offline" {
+			set = offline
+		}
+	})
+	return set
 }
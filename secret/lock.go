@@ -0,0 +1,210 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// 用口令加密的数据密钥文件，存在时表示实例的密钥已加密
+const LOCKFILE = "keystore.lock"
+
+// 数据密钥的长度
+const dataKeySize = chacha20poly1305.KeySize
+
+// 加密数据的前缀，用来区分加密前保存的明文
+var sealedPrefix = []byte("dchannel-sealed-v1:")
+
+var (
+	ErrLocked          = errors.New("keystore is locked, passphrase is required")
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// Box 用数据密钥加解密本地保存的密钥。
+// 口令只用于加密数据密钥（scrypt），修改口令时不需要重新加密所有数据
+type Box struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// Locked 目录中是否有口令加密的数据密钥
+func Locked(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, LOCKFILE))
+	return err == nil
+}
+
+// Unlock 用口令解密dir中的数据密钥，文件不存在时生成新的数据密钥并用口令加密保存
+func Unlock(dir, passphrase string) (*Box, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase can not be empty")
+	}
+
+	path := filepath.Join(dir, LOCKFILE)
+	data, err := os.ReadFile(path)
+
+	var key []byte
+	if os.IsNotExist(err) {
+		key = make([]byte, dataKeySize)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err = writeLockFile(dir, key, passphrase); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s", err.Error())
+	} else if key, err = decryptDataKey(data, passphrase); err != nil {
+		return nil, err
+	}
+
+	return newBox(key)
+}
+
+// ChangePassphrase 用新口令重新加密数据密钥，旧口令错误时返回 ErrWrongPassphrase
+func ChangePassphrase(dir, oldpassphrase, newpassphrase string) error {
+	if newpassphrase == "" {
+		return fmt.Errorf("passphrase can not be empty")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, LOCKFILE))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("keystore is not encrypted, boot with a passphrase first")
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s", err.Error())
+	}
+
+	key, err := decryptDataKey(data, oldpassphrase)
+	if err != nil {
+		return err
+	}
+	defer zero(key)
+
+	return writeLockFile(dir, key, newpassphrase)
+}
+
+func decryptDataKey(data []byte, passphrase string) ([]byte, error) {
+	key, err := DecryptWithPassphrase(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("invalid data key in %s", LOCKFILE)
+	}
+
+	return key, nil
+}
+
+// 先写临时文件再改名，避免写入中断后丢失数据密钥
+func writeLockFile(dir string, key []byte, passphrase string) error {
+	data, err := EncryptWithPassphrase(key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, LOCKFILE)
+	if err = os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write key store %s", err.Error())
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// EncryptWithPassphrase 用口令（scrypt）加密数据，结果为armor格式
+func EncryptWithPassphrase(data []byte, passphrase string) ([]byte, error) {
+	scryptRecipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to NewScryptRecipient %s", err.Error())
+	}
+
+	out := &bytes.Buffer{}
+	if err = Encrypt([]age.Recipient{scryptRecipient}, bytes.NewReader(data), out); err != nil {
+		return nil, fmt.Errorf("failed to password encrypt %s", err.Error())
+	}
+	return out.Bytes(), nil
+}
+
+// DecryptWithPassphrase 解密 EncryptWithPassphrase 的结果，口令错误时返回 ErrWrongPassphrase
+func DecryptWithPassphrase(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("data is not encrypted")
+	}
+
+	scryptIdentity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to NewScryptIdentity %s", err.Error())
+	}
+
+	out := &bytes.Buffer{}
+	if err = Decrypt([]age.Identity{scryptIdentity}, bytes.NewReader(data), out); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return out.Bytes(), nil
+}
+
+func newBox(key []byte) (*Box, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{key: key, aead: aead}, nil
+}
+
+// Seal 加密数据，结果为 前缀+nonce+密文
+func (b *Box) Seal(plain []byte) ([]byte, error) {
+	if b.aead == nil {
+		return nil, ErrLocked
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(append([]byte{}, sealedPrefix...), nonce...)
+	return b.aead.Seal(out, nonce, plain, nil), nil
+}
+
+// Open 解密 Seal 的结果
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	if b.aead == nil {
+		return nil, ErrLocked
+	}
+	if !IsSealed(sealed) {
+		return nil, fmt.Errorf("data is not sealed")
+	}
+
+	sealed = sealed[len(sealedPrefix):]
+	if len(sealed) < b.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	return b.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// IsSealed 数据是否是 Box.Seal 的结果
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedPrefix)
+}
+
+// Close 清除内存中的数据密钥，之后不能再加解密
+func (b *Box) Close() {
+	zero(b.key)
+	b.key = nil
+	b.aead = nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
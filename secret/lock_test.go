@@ -0,0 +1,110 @@
+package secret
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnlock(t *testing.T) {
+	dir := t.TempDir()
+
+	if Locked(dir) {
+		t.Fatal("new dir should not be locked")
+	}
+
+	box, err := Unlock(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Locked(dir) {
+		t.Fatal("expected lock file")
+	}
+
+	sealed, err := box.Seal([]byte("private key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) {
+		t.Fatal("expected sealed prefix")
+	}
+
+	//用同一个口令重新解锁，可以解密之前的数据
+	again, err := Unlock(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := again.Open(sealed)
+	if err != nil || !bytes.Equal(plain, []byte("private key")) {
+		t.Fatalf("got %s %v", plain, err)
+	}
+
+	if _, err = Unlock(dir, "wrong"); err != ErrWrongPassphrase {
+		t.Errorf("got %v, want %v", err, ErrWrongPassphrase)
+	}
+
+	//关闭后不能再使用
+	again.Close()
+	if _, err = again.Open(sealed); err != ErrLocked {
+		t.Errorf("got %v, want %v", err, ErrLocked)
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	dir := t.TempDir()
+
+	box, err := Unlock(dir, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ChangePassphrase(dir, "wrong", "new"); err != ErrWrongPassphrase {
+		t.Fatalf("got %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err = ChangePassphrase(dir, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Unlock(dir, "old"); err != ErrWrongPassphrase {
+		t.Errorf("old passphrase: got %v, want %v", err, ErrWrongPassphrase)
+	}
+
+	//数据密钥不变，之前加密的数据仍然可以解密
+	box, err = Unlock(dir, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := box.Open(sealed); err != nil || string(plain) != "data" {
+		t.Errorf("got %s %v", plain, err)
+	}
+}
+
+func TestLoadKeysSealed(t *testing.T) {
+	dir := t.TempDir()
+
+	plain, err := LoadKeys(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box, err := Unlock(dir, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//明文密钥文件在第一次用口令读取时加密，密钥不变
+	keys, err := LoadKeys(dir, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.RecipientString() != plain.RecipientString() {
+		t.Errorf("got %s, want %s", keys.RecipientString(), plain.RecipientString())
+	}
+
+	if _, err = LoadKeys(dir, nil); err != ErrLocked {
+		t.Errorf("got %v, want %v", err, ErrLocked)
+	}
+}
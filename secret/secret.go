@@ -0,0 +1,171 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// 本地age密钥文件，保存在orbitdb目录下
+const KEYSFILE = "secretkeys.key"
+
+type SecretKeys struct {
+	Identities []age.Identity `json:"identities"`
+	Recipient  age.Recipient  `json:"recipient"`
+}
+type keyJson struct {
+	Identities []string `json:"identities"`
+	Recipient  string   `json:"recipient"`
+}
+
+// LoadKeys 从dir中读取本地的age密钥，文件不存在时生成新的X25519密钥并保存。
+// box不为空时密钥文件用box加密，之前保存的明文文件会被重新加密
+func LoadKeys(dir string, box *Box) (*SecretKeys, error) {
+	path := filepath.Join(dir, KEYSFILE)
+
+	keyjson := keyJson{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, fmt.Errorf("failed to spawn age indetity: %s", err.Error())
+		}
+
+		keyjson.Identities = []string{identity.String()}
+		keyjson.Recipient = identity.Recipient().String()
+
+		if err = writeKeys(dir, keyjson, box); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s", err.Error())
+	} else {
+		sealed := IsSealed(data)
+		if sealed {
+			if box == nil {
+				return nil, ErrLocked
+			}
+			if data, err = box.Open(data); err != nil {
+				return nil, ErrWrongPassphrase
+			}
+		}
+		if err = json.Unmarshal(data, &keyjson); err != nil {
+			return nil, fmt.Errorf("failed to Unmarshal %s", err.Error())
+		}
+		if !sealed && box != nil {
+			if err = writeKeys(dir, keyjson, box); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	keys := &SecretKeys{}
+	keys.Recipient, err = age.ParseX25519Recipient(keyjson.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key Recipient %s", err.Error())
+	}
+
+	for _, s := range keyjson.Identities {
+		id, err := age.ParseX25519Identity(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identities %s", err.Error())
+		}
+		keys.Identities = append(keys.Identities, id)
+	}
+
+	return keys, nil
+}
+
+func writeKeys(dir string, keyjson keyJson, box *Box) error {
+	data, err := json.Marshal(keyjson)
+	if err != nil {
+		return fmt.Errorf("failed to json format keys %s", err.Error())
+	}
+	if box != nil {
+		if data, err = box.Seal(data); err != nil {
+			return err
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(dir, KEYSFILE), data, 0600); err != nil {
+		return fmt.Errorf("failed to write key store %s", err.Error())
+	}
+	return nil
+}
+
+// RecipientString 本地公钥，提供给其他节点加入加密数据库的接收者列表
+func (keys *SecretKeys) RecipientString() string {
+	return keys.Recipient.(*age.X25519Recipient).String()
+}
+
+// ParseRecipients 解析age公钥（age1...）
+func ParseRecipients(ss []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(ss))
+	for _, s := range ss {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %w", s, err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// IsEncrypted 数据是否是armor格式的age密文
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+func Encrypt(recipients []age.Recipient, in io.Reader, out io.Writer) error {
+
+	a := armor.NewWriter(out)
+	defer func() {
+		if err := a.Close(); err != nil {
+			return
+		}
+	}()
+	out = a
+
+	w, err := age.Encrypt(out, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func Decrypt(identities []age.Identity, in io.Reader, out io.Writer) error {
+	rr := bufio.NewReader(in)
+	var r io.Reader
+	//如果不是amor开头，认为不是加密文件，返回原始数据到out
+	if start, _ := rr.Peek(len(armor.Header)); string(start) == armor.Header {
+		in = armor.NewReader(rr)
+		var err error
+		r, err = age.Decrypt(in, identities...)
+		if err != nil {
+			return err
+		}
+	} else {
+		r = rr
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package secret
+
+import (
+	"bytes"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	keys, err := LoadKeys(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//第二次读取到同一个密钥
+	again, err := LoadKeys(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.RecipientString() != again.RecipientString() {
+		t.Errorf("got %s, want %s", again.RecipientString(), keys.RecipientString())
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys, err := LoadKeys(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadKeys(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := []byte(`{"hello":"world"}`)
+	sealed := &bytes.Buffer{}
+	if err = Encrypt([]age.Recipient{keys.Recipient}, bytes.NewReader(plain), sealed); err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed.Bytes()) {
+		t.Fatal("expected armored ciphertext")
+	}
+
+	out := &bytes.Buffer{}
+	if err = Decrypt(keys.Identities, bytes.NewReader(sealed.Bytes()), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plain) {
+		t.Errorf("got %s, want %s", out.Bytes(), plain)
+	}
+
+	//不是接收者的密钥不能解密
+	if err = Decrypt(other.Identities, bytes.NewReader(sealed.Bytes()), &bytes.Buffer{}); err == nil {
+		t.Error("expected decrypt error for non-recipient")
+	}
+
+	//明文原样返回
+	out.Reset()
+	if err = Decrypt(keys.Identities, bytes.NewReader(plain), out); err != nil || !bytes.Equal(out.Bytes(), plain) {
+		t.Errorf("plaintext passthrough failed: %s %v", out.Bytes(), err)
+	}
+}
+
+func TestParseRecipients(t *testing.T) {
+	keys, err := LoadKeys(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ParseRecipients([]string{keys.RecipientString()}); err != nil {
+		t.Error(err)
+	}
+	if _, err = ParseRecipients([]string{"not-a-key"}); err == nil {
+		t.Error("expected error for invalid recipient")
+	}
+}